@@ -3,11 +3,20 @@ package main
 import (
 	"bsm/internal/backup"
 	"bsm/internal/config"
+	"bsm/internal/logs"
 	"bsm/internal/server"
 	"bsm/internal/worlds"
+	"bsm/utils"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -21,8 +30,16 @@ func main() {
 	switch command {
 	case "config":
 		handleConfig()
+	case "daemon":
+		handleDaemon()
 	case "server":
 		handleServer()
+	case "console":
+		handleConsole()
+	case "exec":
+		handleExec()
+	case "logs":
+		handleLogs()
 	case "world":
 		handleWorlds()
 	case "backup":
@@ -54,12 +71,148 @@ func handleConfig() {
 	fmt.Printf("Config file created at %s\n", configPath)
 }
 
+func handleDaemon() {
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	daemonCmd.Parse(os.Args[2:])
+
+	// Load config
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch daemonCmd.Arg(0) {
+	case "":
+		if err := server.NewSupervisor(cfg).Run(); err != nil {
+			fmt.Printf("Error running supervisor: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "stop":
+		sm := server.NewServerManager(cfg.ServerDirectory)
+		if err := sm.Shutdown(); err != nil {
+			fmt.Printf("Error stopping supervisor: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Supervisor stopped")
+
+	default:
+		fmt.Printf("Unknown daemon subcommand: %s\n", daemonCmd.Arg(0))
+		os.Exit(1)
+	}
+}
+
+func handleConsole() {
+	// Load config
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	sm := server.NewServerManager(cfg.ServerDirectory)
+	fmt.Println("Attached to server console. Press Ctrl-D or Ctrl-C to detach, the server keeps running")
+	if err := sm.Attach(os.Stdin, os.Stdout); err != nil {
+		fmt.Printf("Error attaching to console: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Detached from server console")
+}
+
+func handleExec() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: bsm exec \"<command>\"")
+		fmt.Println("Example: bsm exec \"list\"")
+		os.Exit(1)
+	}
+
+	// Load config
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	sm := server.NewServerManager(cfg.ServerDirectory)
+	lines, err := sm.Exec(strings.Join(os.Args[2:], " "))
+	if err != nil {
+		fmt.Printf("Error sending command: %v\n", err)
+		os.Exit(1)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+func handleLogs() {
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := logsCmd.Bool("f", false, "Keep printing new lines as they are written")
+	since := logsCmd.String("since", "", "Only show lines newer than a duration (2h, 3d) or a time (2006-01-02 15:04)")
+	pattern := logsCmd.String("grep", "", "Only show lines matching a regular expression")
+	parseArgs(logsCmd, os.Args[2:])
+
+	// Load config
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	var filter logs.Filter
+	if *since != "" {
+		filter.Since, err = parseSince(*since)
+		if err != nil {
+			fmt.Printf("Invalid --since value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *pattern != "" {
+		filter.Pattern, err = regexp.Compile(*pattern)
+		if err != nil {
+			fmt.Printf("Invalid --grep pattern: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	logDir := server.LogDirectory(cfg.ServerDirectory)
+	if err := logs.Read(logDir, filter, os.Stdout); err != nil {
+		fmt.Printf("Error reading logs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *follow {
+		if err := logs.Follow(logDir, filter, os.Stdout, nil); err != nil {
+			fmt.Printf("Error following logs: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// parseSince turns a duration like 2h or 3d, or a timestamp, into a point in time
+func parseSince(value string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a duration like 2h or a time like 2006-01-02 15:04")
+}
+
 func handleServer() {
 	serverCmd := flag.NewFlagSet("server", flag.ExitOnError)
 	serverCmd.Parse(os.Args[2:])
 
 	if serverCmd.NArg() < 1 {
-		fmt.Println("Usage: bsm server [setup|start|stop|status|update]")
+		fmt.Println("Usage: bsm server [setup|start|stop|status|crashes|update|versions|rollback]")
 		os.Exit(1)
 	}
 
@@ -75,17 +228,27 @@ func handleServer() {
 
 	switch subcommand {
 	case "setup":
-		if serverCmd.NArg() < 2 {
-			fmt.Println("Usage: bsm server setup [version]")
+		setupCmd := flag.NewFlagSet("server setup", flag.ExitOnError)
+		force := setupCmd.Bool("force", false, "Reinstall even if the version is already installed")
+		fromFile := setupCmd.String("from-file", "", "Install from a local server archive instead of downloading")
+		checksum := setupCmd.String("sha256", "", "Expected SHA-256 of the server archive")
+		args := parseArgs(setupCmd, serverCmd.Args()[1:])
+
+		if len(args) < 1 && *fromFile == "" {
+			fmt.Println("Usage: bsm server setup [--force] [--sha256 checksum] [version|latest]")
+			fmt.Println("       bsm server setup [--force] --from-file bedrock-server-{version}.zip [version]")
 			fmt.Println("Example: bsm server setup 1.21.51.02")
 			os.Exit(1)
 		}
 
-		version := serverCmd.Arg(1)
-		downloadURL := fmt.Sprintf("https://www.minecraft.net/bedrockdedicatedserver/bin-linux/bedrock-server-%s.zip", version)
-		
-		fmt.Printf("Setting up server version %s...\n", version)
-		if err := server.SetupServer(downloadURL, cfg); err != nil {
+		build := resolveSource(cfg, args, *fromFile, *checksum)
+		if !*force && server.IsVersionInstalled(cfg.ServerDirectory, build.Version) {
+			fmt.Printf("Server version %s is already installed. Use --force to reinstall\n", build.Version)
+			return
+		}
+
+		fmt.Printf("Setting up server version %s...\n", build.Version)
+		if err := server.SetupServer(build, cfg); err != nil {
 			fmt.Printf("Error setting up server: %v\n", err)
 			os.Exit(1)
 		}
@@ -99,8 +262,12 @@ func handleServer() {
 		fmt.Println("Server started successfully")
 
 	case "stop":
+		stopCmd := flag.NewFlagSet("server stop", flag.ExitOnError)
+		countdown := stopCmd.Int("countdown", cfg.StopCountdown, "Seconds to warn players in-game before stopping")
+		parseArgs(stopCmd, serverCmd.Args()[1:])
+
 		fmt.Println("Stopping Bedrock server...")
-		if err := sm.Stop(); err != nil {
+		if err := sm.StopWithCountdown(time.Duration(*countdown) * time.Second); err != nil {
 			fmt.Printf("Error stopping server: %v\n", err)
 			os.Exit(1)
 		}
@@ -114,14 +281,162 @@ func handleServer() {
 		}
 		fmt.Printf("Server status: %s\n", status)
 
+		crashes, err := server.ReadCrashHistory(cfg.ServerDirectory)
+		if err != nil {
+			fmt.Printf("Error reading crash history: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Crashes: %d\n", crashes.Count)
+		if len(crashes.Crashes) > 0 {
+			last := crashes.Crashes[len(crashes.Crashes)-1]
+			fmt.Printf("Last crash: %s (%s)\n", last.Time.Format("2006-01-02 15:04:05"), last.Reason)
+		}
+
+		installed, err := server.ReadInstalledVersion(cfg.ServerDirectory)
+		if err != nil {
+			fmt.Printf("Error reading installed version: %v\n", err)
+			os.Exit(1)
+		}
+		if installed == nil {
+			fmt.Println("Installed version: unknown")
+			return
+		}
+		fmt.Printf("Installed version: %s\n", installed.Version)
+		fmt.Printf("Installed at: %s\n", installed.InstalledAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Download URL: %s\n", installed.DownloadURL)
+		fmt.Printf("Archive SHA-256: %s\n", installed.SHA256)
+
 	case "update":
+		updateCmd := flag.NewFlagSet("server update", flag.ExitOnError)
+		force := updateCmd.Bool("force", false, "Update even if the version is already installed")
+		fromFile := updateCmd.String("from-file", "", "Install from a local server archive instead of downloading")
+		checksum := updateCmd.String("sha256", "", "Expected SHA-256 of the server archive")
+		args := parseArgs(updateCmd, serverCmd.Args()[1:])
+
+		if len(args) < 1 && *fromFile == "" {
+			fmt.Println("Usage: bsm server update [--force] [--sha256 checksum] [version|latest]")
+			fmt.Println("       bsm server update [--force] --from-file bedrock-server-{version}.zip [version]")
+			fmt.Println("Example: bsm server update latest")
+			os.Exit(1)
+		}
+
+		build := resolveSource(cfg, args, *fromFile, *checksum)
+		if !*force && server.IsVersionInstalled(cfg.ServerDirectory, build.Version) {
+			fmt.Printf("Server version %s is already installed. Use --force to reinstall\n", build.Version)
+			return
+		}
+
+		// Ask first, but only stop once the new build is downloaded and extracted
+		wasRunning := confirmMaintenance(sm, "update")
+
+		fmt.Printf("Updating server to version %s...\n", build.Version)
+		update, err := server.StageUpdate(build, cfg)
+		if err != nil {
+			fmt.Printf("Error updating server: %v\n", err)
+			os.Exit(1)
+		}
+		if wasRunning {
+			fmt.Println("Stopping Bedrock server...")
+			if err := sm.Stop(); err != nil {
+				update.Cleanup()
+				fmt.Printf("Error stopping server: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		err = update.Install(wasRunning)
+		update.Cleanup()
+		if err != nil {
+			fmt.Printf("Error updating server: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "versions":
+		available, err := server.FetchAvailableVersions(cfg.VersionsURL)
+		if err != nil {
+			fmt.Printf("Error fetching versions: %v\n", err)
+			os.Exit(1)
+		}
+
+		if available.Release != nil {
+			fmt.Printf("Latest release: %s\n", available.Release.Version)
+		}
+		if available.Preview != nil {
+			fmt.Printf("Latest preview: %s\n", available.Preview.Version)
+		}
+
+		installed, err := server.ReadInstalledVersion(cfg.ServerDirectory)
+		if err == nil && installed != nil {
+			fmt.Printf("Installed: %s\n", installed.Version)
+		}
+
+	case "crashes":
+		crashes, err := server.ReadCrashHistory(cfg.ServerDirectory)
+		if err != nil {
+			fmt.Printf("Error reading crash history: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(crashes.Crashes) == 0 {
+			fmt.Println("No crashes recorded")
+			return
+		}
+
+		fmt.Printf("Total crashes: %d\n", crashes.Count)
+		for _, crash := range crashes.Crashes {
+			fmt.Printf("\n%s: %s\n", crash.Time.Format("2006-01-02 15:04:05"), crash.Reason)
+			for _, line := range crash.LastLogs {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+
+	case "rollback":
 		if serverCmd.NArg() < 2 {
-			fmt.Println("Usage: bsm server update [download_url]")
+			builds, err := server.ListBuilds(cfg.ServerDirectory)
+			if err != nil {
+				fmt.Printf("Error listing server builds: %v\n", err)
+				os.Exit(1)
+			}
+
+			if len(builds) == 0 {
+				fmt.Println("No previous server builds retained")
+				return
+			}
+
+			fmt.Println("Retained server builds:")
+			for _, build := range builds {
+				fmt.Printf("  %s (installed %s)\n", build.Version, build.InstalledAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Println("\nUsage: bsm server rollback [version]")
+			return
+		}
+
+		version := serverCmd.Arg(1)
+
+		// Check the build before stopping so a typo doesn't take the server down
+		if _, err := server.CheckBuild(cfg.ServerDirectory, version); err != nil {
+			fmt.Printf("Error rolling back server: %v\n", err)
+			os.Exit(1)
+		}
+		wasRunning := stopForMaintenance(sm, "roll back")
+
+		fmt.Printf("Rolling back server to version %s...\n", version)
+		rollbackErr := server.RollbackServer(cfg.ServerDirectory, version)
+		if rollbackErr != nil {
+			fmt.Printf("Error rolling back server: %v\n", rollbackErr)
+		} else {
+			fmt.Printf("Server rolled back to version %s\n", version)
+		}
+
+		if wasRunning {
+			fmt.Println("Starting Bedrock server...")
+			if err := sm.Start(); err != nil {
+				fmt.Printf("Error starting server: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if rollbackErr != nil {
 			os.Exit(1)
 		}
-		downloadURL := serverCmd.Arg(1)
-		fmt.Printf("Updating server from URL: %s\n", downloadURL)
-		// TODO: Implement server update
 
 	default:
 		fmt.Printf("Unknown server subcommand: %s\n", subcommand)
@@ -201,7 +516,7 @@ func handleBackup() {
 	backupCmd.Parse(os.Args[2:])
 
 	if backupCmd.NArg() < 1 {
-		fmt.Println("Usage: bsm backup [list|create|restore]")
+		fmt.Println("Usage: bsm backup [list|create|restore|schedule|prune|gc|verify|diff|upload]")
 		os.Exit(1)
 	}
 
@@ -213,10 +528,16 @@ func handleBackup() {
 	}
 
 	bm := backup.NewBackupManager(cfg)
+	defer bm.Close()
 	subcommand := backupCmd.Arg(0)
 
 	switch subcommand {
 	case "list":
+		listCmd := flag.NewFlagSet("backup list", flag.ExitOnError)
+		target := listCmd.String("target", "", "List the backups on a backup target")
+		parseArgs(listCmd, backupCmd.Args()[1:])
+		useTarget(bm, *target)
+
 		backups, err := bm.ListBackups()
 		if err != nil {
 			fmt.Printf("Error listing backups: %v\n", err)
@@ -253,37 +574,430 @@ func handleBackup() {
 		}
 
 	case "restore":
-		if backupCmd.NArg() < 2 {
-			fmt.Println("Usage: bsm backup restore [world_name]")
+		restoreCmd := flag.NewFlagSet("backup restore", flag.ExitOnError)
+		selector := restoreCmd.String("backup", "", "Backup to restore: a file name, a timestamp or \"latest\"")
+		yes := restoreCmd.Bool("yes", false, "Don't ask for confirmation")
+		target := restoreCmd.String("target", "", "Restore from a backup target")
+		as := restoreCmd.String("as", "", "Restore as a new world with this name instead of replacing the world")
+		args := parseArgs(restoreCmd, backupCmd.Args()[1:])
+		useTarget(bm, *target)
+
+		if len(args) < 1 {
+			fmt.Println("Usage: bsm backup restore [world_name] [--backup name|timestamp|latest] [--yes] [--as new_world_name]")
 			os.Exit(1)
 		}
-		worldName := backupCmd.Arg(1)
-		if err := bm.RestoreBackup(worldName); err != nil {
+		worldName := args[0]
+		interactive := utils.IsInteractive()
+
+		var selected *backup.Backup
+		switch {
+		case *selector != "":
+			selected, err = bm.FindBackup(worldName, *selector)
+		case interactive:
+			selected, err = bm.SelectBackup(worldName)
+		default:
+			err = fmt.Errorf("no terminal to choose a backup from, pass --backup")
+		}
+		if err != nil {
 			fmt.Printf("Error restoring backup: %v\n", err)
 			os.Exit(1)
 		}
+
+		// Restoring as a new world replaces nothing, so there is nothing to confirm
+		if *as != "" {
+			if err := bm.RestoreBackupAs(worldName, *selected, *as); err != nil {
+				fmt.Printf("Error restoring backup: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Run \"bsm world switch %s\" to play it\n", *as)
+			return
+		}
+
+		if !*yes {
+			if !interactive {
+				fmt.Println("Error restoring backup: refusing to replace the world without confirmation, pass --yes")
+				os.Exit(1)
+			}
+			fmt.Printf("\nWARNING: This will replace the current world '%s' with the backup from %s\n",
+				worldName, selected.CreatedAt.Format("2006-01-02 15:04:05"))
+			if !utils.PromptBool("Are you sure you want to continue? (yes/no)", false) {
+				fmt.Println("Backup restoration cancelled")
+				os.Exit(1)
+			}
+		}
+
+		if err := bm.RestoreBackup(worldName, *selected); err != nil {
+			fmt.Printf("Error restoring backup: %v\n", err)
+			os.Exit(1)
+		}
+	case "schedule":
+		// Let a running backup finish on Ctrl-C so saves aren't left on hold
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			fmt.Println("Stopping scheduler...")
+			close(stop)
+		}()
+
+		fmt.Printf("Backing up the active world every %d minutes\n", cfg.BackupInterval)
+		if err := bm.RunSchedule(time.Duration(cfg.BackupInterval)*time.Minute, stop); err != nil {
+			fmt.Printf("Error running backup schedule: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "prune":
+		pruneCmd := flag.NewFlagSet("backup prune", flag.ExitOnError)
+		dryRun := pruneCmd.Bool("dry-run", false, "Show which backups would be deleted without deleting them")
+		target := pruneCmd.String("target", "", "Prune the backups on a backup target")
+		args := parseArgs(pruneCmd, backupCmd.Args()[1:])
+		useTarget(bm, *target)
+
+		worldNames := args
+		if len(worldNames) == 0 {
+			worldNames, err = bm.BackupWorlds()
+			if err != nil {
+				fmt.Printf("Error listing backups: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var deleted int
+		var freed int64
+		for _, worldName := range worldNames {
+			decisions, err := bm.PruneBackups(worldName, *dryRun)
+			if err != nil {
+				fmt.Printf("Error pruning backups of '%s': %v\n", worldName, err)
+				os.Exit(1)
+			}
+			if len(decisions) == 0 {
+				continue
+			}
+
+			fmt.Printf("\nWorld: %s\n", worldName)
+			for _, d := range decisions {
+				action := "keep"
+				if !d.Keep {
+					action = "delete"
+					deleted++
+					freed += d.Backup.Size
+				}
+				fmt.Printf("  %-6s  %s (%.2f MB)  %s\n", action,
+					d.Backup.CreatedAt.Format("2006-01-02 15:04:05"),
+					float64(d.Backup.Size)/(1024*1024),
+					strings.Join(d.Reasons, ", "))
+			}
+		}
+
+		if *dryRun {
+			fmt.Printf("\nWould delete %d backups (%.2f MB)\n", deleted, float64(freed)/(1024*1024))
+		} else {
+			fmt.Printf("\nDeleted %d backups (%.2f MB)\n", deleted, float64(freed)/(1024*1024))
+		}
+
+	case "gc":
+		gcCmd := flag.NewFlagSet("backup gc", flag.ExitOnError)
+		dryRun := gcCmd.Bool("dry-run", false, "Show how much would be deleted without deleting it")
+		parseArgs(gcCmd, backupCmd.Args()[1:])
+
+		removed, freed, err := bm.CollectGarbage(*dryRun)
+		if err != nil {
+			fmt.Printf("Error collecting repository garbage: %v\n", err)
+			os.Exit(1)
+		}
+		if *dryRun {
+			fmt.Printf("Would delete %d unused blobs (%.2f MB)\n", removed, float64(freed)/(1024*1024))
+		} else {
+			fmt.Printf("Deleted %d unused blobs (%.2f MB)\n", removed, float64(freed)/(1024*1024))
+		}
+
+	case "verify":
+		verifyCmd := flag.NewFlagSet("backup verify", flag.ExitOnError)
+		all := verifyCmd.Bool("all", false, "Verify the backups of every world")
+		args := parseArgs(verifyCmd, backupCmd.Args()[1:])
+
+		worldNames := args
+		if *all {
+			worldNames, err = bm.BackupWorlds()
+			if err != nil {
+				fmt.Printf("Error listing backups: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(worldNames) == 0 {
+			fmt.Println("Usage: bsm backup verify [world_name] [--all]")
+			os.Exit(1)
+		}
+
+		var checked, failed int
+		for _, worldName := range worldNames {
+			backups, err := bm.WorldBackups(worldName)
+			if err != nil {
+				fmt.Printf("Error verifying backups of '%s': %v\n", worldName, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("\nWorld: %s\n", worldName)
+			for _, b := range backups {
+				checked++
+				result := "OK"
+				if err := bm.VerifyBackup(b); err != nil {
+					failed++
+					result = err.Error()
+				} else if b.Manifest == nil {
+					result = "OK (readable, no manifest to check against)"
+				}
+				fmt.Printf("  %s  %s\n", b.Name, result)
+			}
+		}
+
+		fmt.Printf("\nVerified %d backups, %d failed\n", checked, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case "diff":
+		diffCmd := flag.NewFlagSet("backup diff", flag.ExitOnError)
+		target := diffCmd.String("target", "", "Compare backups on a backup target")
+		args := parseArgs(diffCmd, backupCmd.Args()[1:])
+		useTarget(bm, *target)
+
+		if len(args) < 3 {
+			fmt.Println("Usage: bsm backup diff [world_name] [backup_a] [backup_b]")
+			os.Exit(1)
+		}
+		worldName := args[0]
+
+		var selected [2]*backup.Backup
+		for i, selector := range args[1:3] {
+			if selected[i], err = bm.FindBackup(worldName, selector); err != nil {
+				fmt.Printf("Error comparing backups: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		from, to := selected[0], selected[1]
+
+		diff, err := bm.DiffBackups(*from, *to)
+		if err != nil {
+			fmt.Printf("Error comparing backups: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Changes from %s (%s) to %s (%s)\n", from.Name, from.CreatedAt.Format("2006-01-02 15:04:05"),
+			to.Name, to.CreatedAt.Format("2006-01-02 15:04:05"))
+		if diff.Files.Empty() && diff.LevelDB.Empty() {
+			fmt.Println("\nThe backups are identical")
+			return
+		}
+		printFileChanges("Files", diff.Files)
+		printFileChanges("LevelDB files", diff.LevelDB)
+
+		switch {
+		case diff.LevelDatError != nil:
+			fmt.Printf("\nlevel.dat: changed, but could not be compared: %v\n", diff.LevelDatError)
+		case len(diff.LevelDat) > 0:
+			fmt.Println("\nlevel.dat:")
+			for _, c := range diff.LevelDat {
+				switch {
+				case c.Old == "":
+					fmt.Printf("  + %s = %s\n", c.Name, c.New)
+				case c.New == "":
+					fmt.Printf("  - %s = %s\n", c.Name, c.Old)
+				default:
+					fmt.Printf("  ~ %s: %s -> %s\n", c.Name, c.Old, c.New)
+				}
+			}
+		}
+
+	case "upload":
+		if len(cfg.BackupTargets) == 0 {
+			fmt.Println("No backup_targets configured")
+			return
+		}
+		if err := bm.ProcessUploads(); err != nil {
+			fmt.Printf("Error uploading backups: %v\n", err)
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Printf("Unknown backup subcommand: %s\n", subcommand)
 		os.Exit(1)
 	}
 }
 
+// useTarget points the backup manager at a backup target when one was given with --target
+func useTarget(bm *backup.BackupManager, name string) {
+	if name == "" {
+		return
+	}
+	if err := bm.UseTarget(name); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printFileChanges prints the files added (+), removed (-) and changed (~) between two backups
+func printFileChanges(title string, changes backup.FileChanges) {
+	if changes.Empty() {
+		return
+	}
+	fmt.Printf("\n%s: %d added, %d removed, %d changed\n", title,
+		len(changes.Added), len(changes.Removed), len(changes.Changed))
+	for _, f := range changes.Added {
+		fmt.Printf("  + %s  %s\n", f.Path, formatSize(f.NewSize))
+	}
+	for _, f := range changes.Removed {
+		fmt.Printf("  - %s  %s\n", f.Path, formatSize(f.OldSize))
+	}
+	for _, f := range changes.Changed {
+		delta := f.NewSize - f.OldSize
+		sign := "+"
+		if delta < 0 {
+			sign, delta = "-", -delta
+		}
+		fmt.Printf("  ~ %s  %s -> %s (%s%s)\n", f.Path, formatSize(f.OldSize), formatSize(f.NewSize), sign, formatSize(delta))
+	}
+}
+
+// formatSize formats a file size in the largest unit that keeps it above 1
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// resolveSource returns the server build to install
+// Archives passed with --from-file are imported into the download cache first
+// and must match checksum if one is given
+func resolveSource(cfg *config.Config, args []string, fromFile, checksum string) *server.AvailableVersion {
+	if fromFile == "" {
+		build := resolveVersion(cfg, args[0])
+		build.SHA256 = checksum
+		return build
+	}
+
+	version := ""
+	if len(args) > 0 {
+		version = args[0]
+	}
+
+	version, err := server.ImportArchive(cfg, fromFile, version, checksum)
+	if err != nil {
+		fmt.Printf("Error importing archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	absPath, _ := filepath.Abs(fromFile)
+	return &server.AvailableVersion{Version: version, DownloadURL: "file://" + absPath, SHA256: checksum}
+}
+
+// resolveVersion returns the server build for a version
+// "latest" is looked up at the configured versions URL
+func resolveVersion(cfg *config.Config, version string) *server.AvailableVersion {
+	if version != "latest" {
+		return &server.AvailableVersion{Version: version, DownloadURL: server.DownloadURL(version)}
+	}
+
+	available, err := server.FetchAvailableVersions(cfg.VersionsURL)
+	if err != nil {
+		fmt.Printf("Error fetching latest version: %v\n", err)
+		os.Exit(1)
+	}
+	if available.Release == nil {
+		fmt.Println("No release build found")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Latest release is %s\n", available.Release.Version)
+	return available.Release
+}
+
+// stopForMaintenance asks to stop the server if it is running and reports whether it was
+// Exits if the user declines
+func stopForMaintenance(sm *server.ServerManager, action string) bool {
+	if !confirmMaintenance(sm, action) {
+		return false
+	}
+
+	fmt.Println("Stopping Bedrock server...")
+	if err := sm.Stop(); err != nil {
+		fmt.Printf("Error stopping server: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// confirmMaintenance asks whether the server may be stopped if it is running
+// and reports whether it is. Exits if the user declines
+func confirmMaintenance(sm *server.ServerManager, action string) bool {
+	if !sm.IsRunning() {
+		return false
+	}
+
+	if !utils.PromptBool(fmt.Sprintf("Server is running. Stop it to %s? (yes/no)", action), false) {
+		fmt.Println("Cancelled")
+		os.Exit(1)
+	}
+	return true
+}
+
+// parseArgs parses flags that may appear anywhere among the positional arguments
+// and returns the positional arguments in order
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func printUsage() {
 	fmt.Println(`
 Usage: bsm [command]
 
 Commands:
   config                   Generate config file
-  server setup {version}   Setup new server
-  server start            Start the Bedrock server
-  server stop             Stop the Bedrock server
-  server status           Check server status
-  server update {version}  Update server using download URL
+  daemon                   Run the supervisor that owns the server process
+  daemon stop              Stop the server and the supervisor
+  server setup {version}   Setup new server, {version} can be "latest" (--force to reinstall,
+                           --from-file {zip} to install from a local archive)
+  server start             Start the Bedrock server (starts the supervisor if needed)
+  server stop              Stop the Bedrock server (--countdown {seconds} to warn players)
+  server status            Check server status
+  server crashes           Show recorded crashes with their last log lines
+  server update {version}  Update server to version {version} (--force to reinstall)
+  server versions          Show the latest release and preview versions
+  server rollback [version] List retained builds or roll back to {version}
+  console                  Attach to the live server console
+  exec {command}           Send a console command and print its output
+  logs                     Show server logs (-f to follow, --since 2h, --grep {pattern})
   world list               List all worlds
   world switch {name}      Switch to world {name}
   world create {name}      Create a new world
-  backup list              List all backups
+  backup list              List all backups (--target {name} to list a backup target)
   backup create {name}     Create backup {name}
-  backup restore {name}    Restore backup {name}
+  backup restore {name}    Restore a backup of world {name} (--backup {file|timestamp|latest}
+                           to pick one without the menu, --yes to skip confirmation,
+                           --target {name} to restore from a backup target,
+                           --as {new name} to restore it as a new world)
+  backup schedule          Back up the active world every backup_interval minutes
+  backup prune [name]      Apply the retention policy (--dry-run to only show what would be deleted,
+                           --target {name} to prune a backup target)
+  backup gc                Delete repository blobs no backup uses (--dry-run to only show the total)
+  backup verify [name]     Check backups of world {name} against their manifests (--all for every world)
+  backup diff {name} {a} {b}
+                           Show the files and level.dat fields that changed between two backups
+                           of world {name} (--target {name} to compare backups on a backup target)
+  backup upload            Upload backups still waiting for their backup_targets
   `)
 }
\ No newline at end of file
@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"bsm/utils"
+)
+
+// bundleDir holds everything a backup keeps besides the world itself:
+//
+//	.bsm/config/           the world's directory under worlds_directory
+//	.bsm/permissions.json  the server's permissions.json
+//	.bsm/behavior_packs/   behavior and resource packs the world uses that
+//	.bsm/resource_packs/   are installed on the server rather than in the world
+const bundleDir = ".bsm"
+
+// packTypes are the server directories packs are installed in, each named
+// after the world file that lists the packs a world uses
+var packTypes = []string{"behavior_packs", "resource_packs"}
+
+// bundleFiles lists the configuration and packs to back up with a world
+func (bm *BackupManager) bundleFiles(worldName, worldPath string) ([]utils.TruncatedFile, error) {
+	var files []utils.TruncatedFile
+
+	configDir := filepath.Join(bm.worlds.WorldsDir, worldName)
+	if _, err := os.Stat(configDir); err == nil {
+		configFiles, err := bundleDirFiles(configDir, path.Join(bundleDir, "config"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading world configuration: %v", err)
+		}
+		files = append(files, configFiles...)
+	}
+
+	permissions := filepath.Join(bm.ServerDir, "permissions.json")
+	if info, err := os.Stat(permissions); err == nil {
+		files = append(files, utils.TruncatedFile{
+			Path:   path.Join(bundleDir, "permissions.json"),
+			Size:   info.Size(),
+			Source: permissions,
+		})
+	}
+
+	for _, packType := range packTypes {
+		dirs, err := bm.usedPacks(worldPath, packType)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			packFiles, err := bundleDirFiles(filepath.Join(bm.ServerDir, packType, dir), path.Join(bundleDir, packType, dir))
+			if err != nil {
+				return nil, fmt.Errorf("error reading pack %s: %v", dir, err)
+			}
+			files = append(files, packFiles...)
+		}
+	}
+
+	return files, nil
+}
+
+// bundleDirFiles lists the files under dir to be archived under prefix
+func bundleDirFiles(dir, prefix string) ([]utils.TruncatedFile, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		files[i].Source = filepath.Join(dir, filepath.FromSlash(files[i].Path))
+		files[i].Path = path.Join(prefix, files[i].Path)
+	}
+	return files, nil
+}
+
+// packRef is an entry of world_behavior_packs.json or world_resource_packs.json
+type packRef struct {
+	PackID string `json:"pack_id"`
+}
+
+// packManifest is the part of a pack's manifest.json that identifies it
+type packManifest struct {
+	Header struct {
+		UUID string `json:"uuid"`
+	} `json:"header"`
+}
+
+// usedPacks returns the directories under the server's packType directory
+// holding the packs listed in the world's world_<packType>.json
+// Packs stored inside the world are already part of the backup and are skipped
+func (bm *BackupManager) usedPacks(worldPath, packType string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(worldPath, "world_"+packType+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var refs []packRef
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("error parsing world_%s.json: %v", packType, err)
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	installed := make(map[string]string)
+	entries, _ := os.ReadDir(filepath.Join(bm.ServerDir, packType))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(bm.ServerDir, packType, entry.Name(), "manifest.json"))
+		if err != nil {
+			continue
+		}
+		var manifest packManifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.Header.UUID != "" {
+			installed[strings.ToLower(manifest.Header.UUID)] = entry.Name()
+		}
+	}
+
+	var dirs []string
+	for _, ref := range refs {
+		if dir, ok := installed[strings.ToLower(ref.PackID)]; ok {
+			dirs = append(dirs, dir)
+		} else if _, err := os.Stat(filepath.Join(worldPath, packType)); err != nil {
+			fmt.Printf("Warning: pack %s used by the world is not installed, it won't be backed up\n", ref.PackID)
+		}
+	}
+	return dirs, nil
+}
+
+// restoreBundle puts the configuration and packs bundled with a restored world
+// back in place and removes the bundle from the world. Backups made before
+// bundles existed restore the world alone
+func (bm *BackupManager) restoreBundle(worldName, worldPath string) error {
+	bundle := filepath.Join(worldPath, bundleDir)
+	if _, err := os.Stat(bundle); err != nil {
+		return nil
+	}
+	defer os.RemoveAll(bundle)
+
+	configDir := filepath.Join(bundle, "config")
+	if _, err := os.Stat(configDir); err == nil {
+		if err := utils.CopyPath(configDir, filepath.Join(bm.worlds.WorldsDir, worldName)); err != nil {
+			return fmt.Errorf("error restoring world configuration: %v", err)
+		}
+	}
+
+	permissions := filepath.Join(bundle, "permissions.json")
+	if _, err := os.Stat(permissions); err == nil {
+		if err := utils.CopyFile(permissions, filepath.Join(bm.ServerDir, "permissions.json")); err != nil {
+			return fmt.Errorf("error restoring permissions.json: %v", err)
+		}
+	}
+
+	// Replace the installed copies so the packs match the world again
+	if err := bm.restoreBundledPacks(bundle, true); err != nil {
+		return err
+	}
+
+	// The server reads its own copy of the active world's configuration
+	if active, err := bm.worlds.GetActiveWorld(); err == nil && active == worldName {
+		if err := bm.worlds.SwitchWorld(worldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBundledPacks copies the packs in a bundle to the server, replacing
+// packs that are already installed only if replace is set
+func (bm *BackupManager) restoreBundledPacks(bundle string, replace bool) error {
+	for _, packType := range packTypes {
+		entries, err := os.ReadDir(filepath.Join(bundle, packType))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			dst := filepath.Join(bm.ServerDir, packType, entry.Name())
+			if _, err := os.Stat(dst); err == nil {
+				if !replace {
+					continue
+				}
+				if err := os.RemoveAll(dst); err != nil {
+					return fmt.Errorf("error replacing pack %s: %v", entry.Name(), err)
+				}
+			}
+			if err := utils.CopyPath(filepath.Join(bundle, packType, entry.Name()), dst); err != nil {
+				return fmt.Errorf("error restoring pack %s: %v", entry.Name(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FileChange is a file that differs between two backups
+// OldSize is 0 for added files and NewSize is 0 for removed ones
+type FileChange struct {
+	Path    string
+	OldSize int64
+	NewSize int64
+}
+
+// FileChanges groups the files added, removed and changed between two backups
+type FileChanges struct {
+	Added   []FileChange
+	Removed []FileChange
+	Changed []FileChange
+}
+
+// Empty reports whether no files differ
+func (c FileChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// BackupDiff is what changed from one backup of a world to a later one
+type BackupDiff struct {
+	Files    FileChanges // Everything outside the LevelDB directory
+	LevelDB  FileChanges // Files in db/, where the world's chunks are stored
+	LevelDat []LevelDatChange
+	// LevelDatError is set when level.dat changed but couldn't be compared field by field
+	LevelDatError error
+}
+
+// levelDBDir is where Bedrock keeps the world's LevelDB database
+const levelDBDir = "db/"
+
+// DiffBackups compares two backups of a world
+// File lists come from the backups' manifests when they have one, so only
+// level.dat has to be read from the backups themselves. Backups on a target
+// are downloaded first
+func (bm *BackupManager) DiffBackups(older, newer Backup) (*BackupDiff, error) {
+	for _, backup := range []*Backup{&older, &newer} {
+		if backup.Target == "" {
+			continue
+		}
+		local, cleanup, err := bm.fetchBackup(*backup)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		*backup = *local
+	}
+
+	oldFiles, err := bm.backupFiles(older)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", older.Name, err)
+	}
+	newFiles, err := bm.backupFiles(newer)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", newer.Name, err)
+	}
+
+	diff := &BackupDiff{}
+	levelDatChanged := false
+	for path, newFile := range newFiles {
+		changes := &diff.Files
+		if strings.HasPrefix(path, levelDBDir) {
+			changes = &diff.LevelDB
+		}
+
+		oldFile, ok := oldFiles[path]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, FileChange{Path: path, NewSize: newFile.Size})
+		case oldFile.SHA256 != newFile.SHA256:
+			changes.Changed = append(changes.Changed, FileChange{Path: path, OldSize: oldFile.Size, NewSize: newFile.Size})
+			levelDatChanged = levelDatChanged || path == "level.dat"
+		}
+	}
+	for path, oldFile := range oldFiles {
+		if _, ok := newFiles[path]; ok {
+			continue
+		}
+		changes := &diff.Files
+		if strings.HasPrefix(path, levelDBDir) {
+			changes = &diff.LevelDB
+		}
+		changes.Removed = append(changes.Removed, FileChange{Path: path, OldSize: oldFile.Size})
+	}
+	diff.Files.sort()
+	diff.LevelDB.sort()
+
+	if levelDatChanged {
+		diff.LevelDat, diff.LevelDatError = bm.diffLevelDat(older, newer)
+	}
+	return diff, nil
+}
+
+func (c *FileChanges) sort() {
+	for _, list := range [][]FileChange{c.Added, c.Removed, c.Changed} {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Path < list[j].Path
+		})
+	}
+}
+
+// backupFiles returns the files of a backup by path, from its manifest if it has one
+func (bm *BackupManager) backupFiles(backup Backup) (map[string]ManifestFile, error) {
+	var list []ManifestFile
+	if backup.Manifest != nil {
+		list = backup.Manifest.Files
+	} else {
+		var err error
+		if list, err = bm.hashBackup(backup); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make(map[string]ManifestFile)
+	for _, f := range list {
+		files[f.Path] = f
+	}
+	return files, nil
+}
+
+func (bm *BackupManager) diffLevelDat(older, newer Backup) ([]LevelDatChange, error) {
+	oldData, err := bm.readBackupFile(older, "level.dat")
+	if err != nil {
+		return nil, err
+	}
+	newData, err := bm.readBackupFile(newer, "level.dat")
+	if err != nil {
+		return nil, err
+	}
+
+	oldFields, err := parseLevelDat(oldData)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := parseLevelDat(newData)
+	if err != nil {
+		return nil, err
+	}
+	return diffLevelDat(oldFields, newFields), nil
+}
+
+// readBackupFile returns the contents of one file in a backup
+func (bm *BackupManager) readBackupFile(backup Backup, name string) ([]byte, error) {
+	var data []byte
+	found := false
+	err := bm.walkBackup(backup, func(path string, r io.Reader) error {
+		if path != name || found {
+			return nil
+		}
+		found = true
+		var err error
+		data, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no %s", backup.Name, name)
+	}
+	return data, nil
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"bsm/utils"
+)
+
+// encryptedExt is appended to the name of encrypted archives
+const encryptedExt = ".enc"
+
+// encryptionKey loads the configured key, once
+// The key is needed to read encrypted backups even when encryption is disabled
+func (bm *BackupManager) encryptionKey() (*utils.EncryptionKey, error) {
+	if bm.key == nil {
+		key, err := utils.LoadEncryptionKey(bm.Encryption.KeyFile, bm.Encryption.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		bm.key = key
+	}
+	return bm.key, nil
+}
+
+// createArchive creates an archive file at path and returns the writer to fill it
+// through, encrypting when encryption is enabled. Closing the writer closes the file
+func (bm *BackupManager) createArchive(path string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bm.Encryption.Enabled {
+		return file, nil
+	}
+
+	key, err := bm.encryptionKey()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer, err := utils.NewEncryptWriter(file, key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &encryptedFile{WriteCloser: writer, file: file}, nil
+}
+
+// encryptedFile closes the encryption stream before the file under it
+type encryptedFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (e *encryptedFile) Close() error {
+	if err := e.WriteCloser.Close(); err != nil {
+		e.file.Close()
+		return err
+	}
+	return e.file.Close()
+}
+
+// plainArchive returns the path of a decrypted copy of an archive, or the
+// archive itself if it isn't encrypted. The cleanup function removes the copy
+// The copy is kept in the system temp directory, readable only by its owner,
+// so decrypted worlds never sit in the backup directory or on a target mount
+func (bm *BackupManager) plainArchive(path string) (string, func(), error) {
+	if !utils.IsEncrypted(path) {
+		return path, func() {}, nil
+	}
+
+	key, err := bm.encryptionKey()
+	if err != nil {
+		return "", nil, fmt.Errorf("backup is encrypted: %v", err)
+	}
+
+	// CreateTemp makes the file with mode 0600
+	tmp, err := os.CreateTemp("", "bsm-decrypt-*")
+	if err != nil {
+		return "", nil, err
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	if err := utils.DecryptFile(path, tmp.Name(), key); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bsm/utils"
+)
+
+const (
+	// saveQueryAttempts is how many times save query is polled before giving up
+	saveQueryAttempts = 60
+
+	// saveQueryInterval is the pause between save query polls
+	saveQueryInterval = time.Second
+)
+
+// isLive reports whether the world is loaded by a running server
+func (bm *BackupManager) isLive(worldName string) bool {
+	if !bm.server.IsRunning() {
+		return false
+	}
+
+	activeWorld, err := bm.worlds.GetActiveWorld()
+	return err == nil && activeWorld == worldName
+}
+
+// hotBackup copies a world the server has loaded without stopping it
+// Saving is put on hold, the server reports which files to copy and how much
+// of each is consistent, write copies them, and saving resumes once it is done
+func (bm *BackupManager) hotBackup(worldName string, write func(files []utils.TruncatedFile) error) (err error) {
+	if _, err := bm.server.Exec("save hold"); err != nil {
+		return fmt.Errorf("error holding saves: %v", err)
+	}
+	defer func() {
+		if _, resumeErr := bm.server.Exec("save resume"); resumeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("error resuming saves: %v", resumeErr)
+			} else {
+				fmt.Printf("Warning: error resuming saves: %v\n", resumeErr)
+			}
+		}
+	}()
+
+	var files []utils.TruncatedFile
+	for attempt := 0; attempt < saveQueryAttempts; attempt++ {
+		lines, err := bm.server.Exec("save query")
+		if err != nil {
+			return fmt.Errorf("error querying save state: %v", err)
+		}
+		if files = parseSaveQuery(lines); files != nil {
+			break
+		}
+		time.Sleep(saveQueryInterval)
+	}
+	if files == nil {
+		return fmt.Errorf("server did not finish preparing the world for backup")
+	}
+
+	// Paths are reported relative to the worlds directory, starting with the world name
+	for i, f := range files {
+		files[i].Path = strings.TrimPrefix(f.Path, worldName+"/")
+	}
+
+	return write(files)
+}
+
+// parseSaveQuery extracts the file list from the output of "save query"
+// Returns nil if the files are not ready to be copied yet
+func parseSaveQuery(lines []string) []utils.TruncatedFile {
+	for i, line := range lines {
+		if !strings.Contains(line, "Files are now ready to be copied") || i+1 >= len(lines) {
+			continue
+		}
+
+		// The next line lists "path:length" entries separated by ", "
+		var files []utils.TruncatedFile
+		for _, entry := range strings.Split(lines[i+1], ", ") {
+			sep := strings.LastIndex(entry, ":")
+			if sep < 0 {
+				return nil
+			}
+			size, err := strconv.ParseInt(strings.TrimSpace(entry[sep+1:]), 10, 64)
+			if err != nil {
+				return nil
+			}
+			files = append(files, utils.TruncatedFile{Path: strings.TrimSpace(entry[:sep]), Size: size})
+		}
+		return files
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package backup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NBT tag types
+const (
+	tagEnd = iota
+	tagByte
+	tagShort
+	tagInt
+	tagLong
+	tagFloat
+	tagDouble
+	tagByteArray
+	tagString
+	tagList
+	tagCompound
+	tagIntArray
+	tagLongArray
+)
+
+// parseLevelDat reads a Bedrock level.dat into a flat map of field names to values
+// The file is an 8 byte header, storage version and length, followed by
+// little-endian NBT. Nested fields are named like abilities.flySpeed
+func parseLevelDat(data []byte) (map[string]string, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("level.dat is too short")
+	}
+	length := binary.LittleEndian.Uint32(data[4:8])
+	if int64(length) != int64(len(data)-8) {
+		return nil, fmt.Errorf("level.dat header says %d bytes but holds %d", length, len(data)-8)
+	}
+
+	r := &nbtReader{data: data[8:]}
+	tag, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagCompound {
+		return nil, fmt.Errorf("level.dat does not start with a compound tag")
+	}
+	if _, err := r.string(); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	if err := r.compound("", fields); err != nil {
+		return nil, fmt.Errorf("error parsing level.dat: %v", err)
+	}
+	return fields, nil
+}
+
+// LevelDatChange is a level.dat field that differs between two backups
+// Old or New is empty if the field only exists in one of them
+type LevelDatChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// diffLevelDat compares the fields of two level.dat files, sorted by name
+func diffLevelDat(older, newer map[string]string) []LevelDatChange {
+	var changes []LevelDatChange
+	for name, value := range older {
+		if value != newer[name] {
+			changes = append(changes, LevelDatChange{Name: name, Old: value, New: newer[name]})
+		}
+	}
+	for name, value := range newer {
+		if _, ok := older[name]; !ok {
+			changes = append(changes, LevelDatChange{Name: name, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+type nbtReader struct {
+	data []byte
+}
+
+func (r *nbtReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *nbtReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *nbtReader) int32() (int32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (r *nbtReader) string() (string, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return "", err
+	}
+	s, err := r.next(int(binary.LittleEndian.Uint16(b)))
+	return string(s), err
+}
+
+// compound reads the fields of a compound tag into fields, prefixed with prefix
+func (r *nbtReader) compound(prefix string, fields map[string]string) error {
+	for {
+		tag, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if tag == tagEnd {
+			return nil
+		}
+		name, err := r.string()
+		if err != nil {
+			return err
+		}
+		if err := r.value(tag, prefix+name, fields); err != nil {
+			return err
+		}
+	}
+}
+
+// value reads a value of the given tag type and stores it under name
+func (r *nbtReader) value(tag byte, name string, fields map[string]string) error {
+	switch tag {
+	case tagByte:
+		b, err := r.byte()
+		fields[name] = strconv.Itoa(int(int8(b)))
+		return err
+	case tagShort:
+		b, err := r.next(2)
+		if err != nil {
+			return err
+		}
+		fields[name] = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))
+	case tagInt:
+		v, err := r.int32()
+		fields[name] = strconv.Itoa(int(v))
+		return err
+	case tagLong:
+		b, err := r.next(8)
+		if err != nil {
+			return err
+		}
+		fields[name] = strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10)
+	case tagFloat:
+		b, err := r.next(4)
+		if err != nil {
+			return err
+		}
+		fields[name] = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+	case tagDouble:
+		b, err := r.next(8)
+		if err != nil {
+			return err
+		}
+		fields[name] = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+	case tagString:
+		s, err := r.string()
+		fields[name] = s
+		return err
+	case tagByteArray, tagIntArray, tagLongArray:
+		n, err := r.int32()
+		if err != nil {
+			return err
+		}
+		size := map[byte]int{tagByteArray: 1, tagIntArray: 4, tagLongArray: 8}[tag]
+		b, err := r.next(int(n) * size)
+		if err != nil {
+			return err
+		}
+		// Arrays are too long to show, a checksum still tells when they change
+		hash := fnv.New32a()
+		hash.Write(b)
+		fields[name] = fmt.Sprintf("[%d values, %08x]", n, hash.Sum32())
+	case tagList:
+		itemTag, err := r.byte()
+		if err != nil {
+			return err
+		}
+		n, err := r.int32()
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("negative list length for %s", name)
+		}
+		var items []string
+		for i := 0; i < int(n); i++ {
+			itemName := fmt.Sprintf("%s[%d]", name, i)
+			if err := r.value(itemTag, itemName, fields); err != nil {
+				return err
+			}
+			if v, ok := fields[itemName]; ok && itemTag != tagCompound && itemTag != tagList {
+				items = append(items, v)
+				delete(fields, itemName)
+			}
+		}
+		// Lists of plain values read better on one line
+		if itemTag != tagCompound && itemTag != tagList {
+			fields[name] = "[" + strings.Join(items, ", ") + "]"
+		}
+	case tagCompound:
+		return r.compound(name+".", fields)
+	default:
+		return fmt.Errorf("unknown tag type %d for %s", tag, name)
+	}
+	return nil
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"bsm/internal/config"
+	"bsm/internal/server"
+	"bsm/internal/worlds"
 	"bsm/utils"
 )
 
@@ -17,6 +19,8 @@ type Backup struct {
 	Path      string
 	Size      int64
 	CreatedAt time.Time
+	Manifest  *Manifest // nil for backups made before manifests existed
+	Target    string    // Backup target holding the backup, empty for local backups
 }
 
 type WorldBackups struct {
@@ -30,6 +34,18 @@ type BackupManager struct {
 	ServerDir     string
 	BackupDir     string
 	MaxBackups    int
+	Retention     config.RetentionPolicy
+	Backend       string
+	Format        string
+	Level         int
+	Targets       []config.BackupTargetConfig
+	Encryption    config.EncryptionConfig
+
+	server  *server.ServerManager
+	worlds  *worlds.WorldManager
+	remote  BackupTarget            // Set by UseTarget to read backups from a target
+	targets map[string]BackupTarget // Targets opened so far, by name
+	key     *utils.EncryptionKey
 }
 
 func NewBackupManager(cfg *config.Config) *BackupManager {
@@ -37,6 +53,14 @@ func NewBackupManager(cfg *config.Config) *BackupManager {
 		ServerDir:  cfg.ServerDirectory,
 		BackupDir:  cfg.BackupDirectory,
 		MaxBackups: cfg.BackupsToKeep,
+		Retention:  cfg.Retention,
+		Backend:    cfg.BackupBackend,
+		Format:     cfg.BackupFormat,
+		Level:      cfg.CompressionLevel,
+		Targets:    cfg.BackupTargets,
+		Encryption: cfg.Encryption,
+		server:     server.NewServerManager(cfg.ServerDirectory),
+		worlds:     worlds.NewWorldManager(cfg.ServerDirectory, cfg.WorldsDirectory, cfg.WorldDefaults, cfg.ServerName),
 	}
 }
 
@@ -45,20 +69,14 @@ func (bm *BackupManager) ListBackups() ([]WorldBackups, error) {
 	var worldBackups []WorldBackups
 	
 	// Read backup directory
-	entries, err := os.ReadDir(bm.BackupDir)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("error reading backup directory: %v", err)
+	worldNames, err := bm.BackupWorlds()
+	if err != nil {
+		return nil, err
 	}
 
 	// Process each world's backups
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		worldName := entry.Name()
-		worldBackupDir := filepath.Join(bm.BackupDir, worldName)
-		backups, totalSize, err := bm.getWorldBackups(worldBackupDir)
+	for _, worldName := range worldNames {
+		backups, totalSize, err := bm.worldBackups(worldName)
 		if err != nil {
 			return nil, fmt.Errorf("error getting backups for %s: %v", worldName, err)
 		}
@@ -94,16 +112,31 @@ func (bm *BackupManager) CreateBackup(worldName string) error {
 
 	// Create backup directory for this world
 	worldBackupDir := filepath.Join(bm.BackupDir, worldName)
-	if err := os.MkdirAll(worldBackupDir, 0755); err != nil {
+	err := os.MkdirAll(worldBackupDir, 0755)
+	if err != nil {
 		return fmt.Errorf("error creating backup directory: %v", err)
 	}
 
 	// Create backup filename with timestamp
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	backupPath := filepath.Join(worldBackupDir, fmt.Sprintf("%s_%s.zip", worldName, timestamp))
-
-	// Create zip file
-	if err := utils.ZipDirectory(worldPath, backupPath); err != nil {
+	createdAt := time.Now()
+	timestamp := createdAt.Format("2006-01-02_15-04-05")
+	backupPath := filepath.Join(worldBackupDir, fmt.Sprintf("%s_%s%s", worldName, timestamp, bm.backupExt()))
+
+	// Copy through the server while it has the world loaded, straight from disk otherwise
+	if bm.isLive(worldName) {
+		fmt.Println("Server is running, creating hot backup...")
+		err = bm.hotBackup(worldName, func(files []utils.TruncatedFile) error {
+			return bm.writeBackup(worldName, worldPath, files, backupPath)
+		})
+	} else {
+		err = bm.writeBackup(worldName, worldPath, nil, backupPath)
+	}
+	if err == nil {
+		err = bm.writeManifest(worldName, backupPath, createdAt)
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		os.Remove(manifestPath(backupPath))
 		return fmt.Errorf("error creating backup: %v", err)
 	}
 
@@ -113,32 +146,116 @@ func (bm *BackupManager) CreateBackup(worldName string) error {
 	}
 
 	fmt.Printf("Created backup of '%s' at %s\n", worldName, backupPath)
+
+	// Copy the backup to the configured targets in the background
+	if len(bm.Targets) > 0 {
+		if err := bm.queueUploads(worldName, filepath.Base(backupPath)); err != nil {
+			fmt.Printf("Warning: error queueing uploads: %v\n", err)
+		} else if err := bm.startUploader(); err != nil {
+			fmt.Printf("Warning: %v. Run \"bsm backup upload\" to upload it\n", err)
+		} else {
+			fmt.Printf("Uploading to backup targets in the background, see %s\n", filepath.Join(bm.BackupDir, "upload.log"))
+		}
+	}
 	return nil
 }
 
-// RestoreBackup restores a world from a backup
-func (bm *BackupManager) RestoreBackup(worldName string) error {
-	// First check if we have write permissions to the worlds directory
-	testPath := filepath.Join(bm.ServerDir, "worlds", ".test_write")
-	if err := os.WriteFile(testPath, []byte("test"), 0644); err != nil {
-		return fmt.Errorf("insufficient permissions to modify worlds directory. Please run with appropriate permissions")
+// backupExt returns the file extension of new backups for the configured backend
+func (bm *BackupManager) backupExt() string {
+	if bm.Backend == "repository" {
+		return snapshotExt
+	}
+	return bm.archiveExt()
+}
+
+// archiveExt returns the file extension of new archives in the configured format
+func (bm *BackupManager) archiveExt() string {
+	ext := "." + bm.Format
+	if bm.Encryption.Enabled {
+		ext += encryptedExt
 	}
-	os.Remove(testPath)
+	return ext
+}
 
-	worldBackupDir := filepath.Join(bm.BackupDir, worldName)
-	backups, _, err := bm.getWorldBackups(worldBackupDir)
+// writeBackup stores a copy of a world and its bundle at backupPath using the
+// configured backend. files lists what a hot backup may copy, nil copies the
+// whole directory
+func (bm *BackupManager) writeBackup(worldName, src string, files []utils.TruncatedFile, backupPath string) error {
+	if files == nil {
+		var err error
+		if files, err = listFiles(src); err != nil {
+			return err
+		}
+	}
+	bundle, err := bm.bundleFiles(worldName, src)
 	if err != nil {
-		return fmt.Errorf("error getting backups: %v", err)
+		return err
 	}
+	files = append(files, bundle...)
 
-	if len(backups) == 0 {
-		return fmt.Errorf("no backups found for world '%s'", worldName)
+	if bm.Backend == "repository" {
+		return bm.repository().WriteSnapshot(worldName, src, files, backupPath)
 	}
 
-	// Sort backups by creation time (newest first)
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	out, err := bm.createArchive(backupPath)
+	if err != nil {
+		return err
+	}
+	err = utils.WriteArchive(out, src, files, bm.Format, bm.Level)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// extractBackup writes the contents of a backup to dest, whichever backend made it
+func (bm *BackupManager) extractBackup(backup Backup, dest string) error {
+	if strings.HasSuffix(backup.Name, snapshotExt) {
+		return bm.repository().Restore(backup.Path, dest)
+	}
+
+	archivePath, cleanup, err := bm.plainArchive(backup.Path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return utils.ExtractArchive(archivePath, dest)
+}
+
+// listFiles lists every regular file under dir at its current size
+func listFiles(dir string) ([]utils.TruncatedFile, error) {
+	var files []utils.TruncatedFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, utils.TruncatedFile{Path: filepath.ToSlash(relPath), Size: info.Size()})
+		return nil
 	})
+	return files, err
+}
+
+// backupBaseName returns a backup's file name without its extension
+func backupBaseName(name string) string {
+	name = strings.TrimSuffix(name, encryptedExt)
+	for _, format := range utils.ArchiveFormats {
+		if strings.HasSuffix(name, "."+format) {
+			return strings.TrimSuffix(name, "."+format)
+		}
+	}
+	return strings.TrimSuffix(name, snapshotExt)
+}
+
+// SelectBackup shows the world's backups and asks which one to restore
+func (bm *BackupManager) SelectBackup(worldName string) (*Backup, error) {
+	backups, err := bm.sortedBackups(worldName)
+	if err != nil {
+		return nil, err
+	}
 
 	// Display available backups
 	fmt.Printf("Available backups for '%s':\n", worldName)
@@ -154,45 +271,272 @@ func (bm *BackupManager) RestoreBackup(worldName string) error {
 	fmt.Scanln(&selection)
 
 	if selection == 0 {
-		return fmt.Errorf("backup restoration cancelled")
+		return nil, fmt.Errorf("backup restoration cancelled")
 	}
 	if selection < 1 || selection > len(backups) {
-		return fmt.Errorf("invalid backup selection")
+		return nil, fmt.Errorf("invalid backup selection")
 	}
 
-	selectedBackup := backups[selection-1]
+	return &backups[selection-1], nil
+}
 
-	// Confirm restoration
-	fmt.Printf("\nWARNING: This will replace the current world '%s' with the backup from %s\n", 
-		worldName, selectedBackup.CreatedAt.Format("2006-01-02 15:04:05"))
-	fmt.Print("Are you sure you want to continue? (y/n): ")
-	
-	var confirm string
-	fmt.Scanln(&confirm)
-	confirm = strings.ToLower(confirm)
-	if confirm != "yes" && confirm != "y" {
-		return fmt.Errorf("backup restoration cancelled")
+// FindBackup looks up one of the world's backups without prompting
+// The selector is "latest", a backup file name, or a timestamp such as
+// 2006-01-02_15-04-05 or 2006-01-02 15:04. Less precise timestamps pick the
+// newest backup they match
+func (bm *BackupManager) FindBackup(worldName, selector string) (*Backup, error) {
+	backups, err := bm.sortedBackups(worldName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Perform restoration
-	worldPath := filepath.Join(bm.ServerDir, "worlds", worldName)
+	if selector == "latest" {
+		return &backups[0], nil
+	}
+
+	for i, backup := range backups {
+		if backup.Name == selector || backupBaseName(backup.Name) == selector {
+			return &backups[i], nil
+		}
+	}
+
+	for _, layout := range backupTimeLayouts {
+		if _, err := time.ParseInLocation(layout, selector, time.Local); err != nil {
+			continue
+		}
+		for i, backup := range backups {
+			if backup.CreatedAt.Local().Format(layout) == selector {
+				return &backups[i], nil
+			}
+		}
+		break
+	}
+
+	return nil, fmt.Errorf("no backup of '%s' matches '%s'", worldName, selector)
+}
+
+// backupTimeLayouts are the timestamp formats FindBackup accepts, most precise first
+var backupTimeLayouts = []string{
+	"2006-01-02_15-04-05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// WorldBackups returns all backups of a world, newest first
+func (bm *BackupManager) WorldBackups(worldName string) ([]Backup, error) {
+	backups, _, err := bm.worldBackups(worldName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting backups: %v", err)
+	}
+
+	// Sort backups by creation time (newest first)
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// sortedBackups returns the world's backups newest first, or an error if it has none
+func (bm *BackupManager) sortedBackups(worldName string) ([]Backup, error) {
+	backups, err := bm.WorldBackups(worldName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no backups found for world '%s'", worldName)
+	}
+	return backups, nil
+}
+
+// RestoreBackup replaces a world with the contents of one of its backups
+// The backup is extracted and checked next to the world first, so a failed
+// restore leaves the current world untouched. If the world is live the server
+// is stopped for the swap and started again afterwards, even if the swap fails.
+// The replaced world is kept as a pre-restore backup
+func (bm *BackupManager) RestoreBackup(worldName string, backup Backup) (err error) {
+	if backup.Target != "" {
+		local, cleanup, err := bm.fetchBackup(backup)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		backup = *local
+	}
+
+	worldsDir := filepath.Join(bm.ServerDir, "worlds")
+	worldPath := filepath.Join(worldsDir, worldName)
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+
+	// Stage on the same filesystem as the world so the swap is a rename
+	stagingPath := filepath.Join(worldsDir, fmt.Sprintf(".restore-%s-%s", worldName, timestamp))
+	defer os.RemoveAll(stagingPath)
+	if err := bm.stageBackup(backup, stagingPath); err != nil {
+		return err
+	}
+
+	wasLive := bm.isLive(worldName)
+	if wasLive {
+		fmt.Printf("World '%s' is active, stopping server...\n", worldName)
+		if err := bm.server.Stop(); err != nil {
+			return fmt.Errorf("error stopping server: %v", err)
+		}
 
-	// Remove existing world if it exists
+		// Bring the server back whether or not the restore worked
+		defer func() {
+			fmt.Println("Starting server...")
+			if startErr := bm.server.Start(); startErr != nil {
+				if err != nil {
+					err = fmt.Errorf("%v (and the server failed to start: %v)", err, startErr)
+				} else {
+					err = fmt.Errorf("world restored but the server failed to start: %v", startErr)
+				}
+			}
+		}()
+	}
+
+	replacedPath := ""
 	if _, err := os.Stat(worldPath); err == nil {
-		if err := os.RemoveAll(worldPath); err != nil {
-			return fmt.Errorf("error removing existing world: %v", err)
+		replacedPath = filepath.Join(worldsDir, fmt.Sprintf(".replaced-%s-%s", worldName, timestamp))
+		if err := os.Rename(worldPath, replacedPath); err != nil {
+			return fmt.Errorf("error moving current world aside: %v", err)
 		}
 	}
+	if err := os.Rename(stagingPath, worldPath); err != nil {
+		if replacedPath != "" {
+			os.Rename(replacedPath, worldPath)
+		}
+		return fmt.Errorf("error swapping in restored world: %v", err)
+	}
 
-	// Extract backup
-	if err := utils.UnzipFile(selectedBackup.Path, filepath.Join(bm.ServerDir, "worlds"), worldName); err != nil {
-		return fmt.Errorf("error restoring backup: %v", err)
+	// The pre-restore backup bundles the configuration and packs in use,
+	// so it has to be made before the restored ones replace them
+	if replacedPath != "" {
+		if err := bm.keepReplacedWorld(worldName, replacedPath, timestamp); err != nil {
+			fmt.Printf("Warning: %v. The replaced world was left at %s\n", err, replacedPath)
+		} else {
+			os.RemoveAll(replacedPath)
+		}
 	}
 
+	if err := bm.restoreBundle(worldName, worldPath); err != nil {
+		fmt.Printf("Warning: world restored but %v\n", err)
+	}
 	fmt.Printf("Successfully restored '%s' from backup\n", worldName)
+
+	return nil
+}
+
+// stageBackup extracts a backup to stagingPath and checks it holds a world
+func (bm *BackupManager) stageBackup(backup Backup, stagingPath string) error {
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return fmt.Errorf("insufficient permissions to modify worlds directory. Please run with appropriate permissions")
+	}
+
+	fmt.Printf("Extracting backup %s...\n", backup.Name)
+	if err := bm.extractBackup(backup, stagingPath); err != nil {
+		return fmt.Errorf("error extracting backup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stagingPath, "level.dat")); err != nil {
+		return fmt.Errorf("backup %s does not contain a world (level.dat is missing)", backup.Name)
+	}
+	return nil
+}
+
+// RestoreBackupAs restores a backup of worldName as a new world called newName,
+// leaving worldName untouched. The new world gets its own entry in the worlds
+// directory with the backed up properties and allowlist
+func (bm *BackupManager) RestoreBackupAs(worldName string, backup Backup, newName string) error {
+	if newName == "" || newName != filepath.Base(newName) || strings.HasPrefix(newName, ".") {
+		return fmt.Errorf("invalid world name '%s'", newName)
+	}
+
+	worldsDir := filepath.Join(bm.ServerDir, "worlds")
+	worldPath := filepath.Join(worldsDir, newName)
+	if _, err := os.Stat(worldPath); err == nil {
+		return fmt.Errorf("world '%s' already exists in the server directory", newName)
+	}
+	if _, err := os.Stat(filepath.Join(bm.worlds.WorldsDir, newName)); err == nil {
+		return fmt.Errorf("world '%s' already exists in %s", newName, bm.worlds.WorldsDir)
+	}
+
+	if backup.Target != "" {
+		local, cleanup, err := bm.fetchBackup(backup)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		backup = *local
+	}
+
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	stagingPath := filepath.Join(worldsDir, fmt.Sprintf(".restore-%s-%s", newName, timestamp))
+	defer os.RemoveAll(stagingPath)
+	if err := bm.stageBackup(backup, stagingPath); err != nil {
+		return err
+	}
+
+	// The server shows the name in levelname.txt, keep it apart from the original
+	if err := os.WriteFile(filepath.Join(stagingPath, "levelname.txt"), []byte(newName), 0644); err != nil {
+		return fmt.Errorf("error writing levelname.txt: %v", err)
+	}
+
+	// Use the configuration the backup was made with, or the original world's
+	// for backups made before it was bundled
+	configDir := filepath.Join(stagingPath, bundleDir, "config")
+	if _, err := os.Stat(configDir); err != nil {
+		configDir = filepath.Join(bm.worlds.WorldsDir, worldName)
+	}
+	if err := bm.worlds.ImportWorld(newName, configDir); err != nil {
+		os.RemoveAll(filepath.Join(bm.worlds.WorldsDir, newName))
+		return err
+	}
+	// Installed packs are left alone since the original world may use them
+	if err := bm.restoreBundledPacks(filepath.Join(stagingPath, bundleDir), false); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	os.RemoveAll(filepath.Join(stagingPath, bundleDir))
+
+	if err := os.Rename(stagingPath, worldPath); err != nil {
+		os.RemoveAll(filepath.Join(bm.worlds.WorldsDir, newName))
+		return fmt.Errorf("error moving restored world into place: %v", err)
+	}
+
+	fmt.Printf("Restored backup of '%s' as new world '%s'\n", worldName, newName)
+	return nil
+}
+
+// keepReplacedWorld saves a world that was replaced by a restore as a pre-restore backup
+func (bm *BackupManager) keepReplacedWorld(worldName, replacedPath, timestamp string) error {
+	worldBackupDir := filepath.Join(bm.BackupDir, worldName)
+	if err := os.MkdirAll(worldBackupDir, 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %v", err)
+	}
+
+	backupPath := filepath.Join(worldBackupDir, fmt.Sprintf("%s_%s_pre-restore%s", worldName, timestamp, bm.backupExt()))
+	err := bm.writeBackup(worldName, replacedPath, nil, backupPath)
+	if err == nil {
+		err = bm.writeManifest(worldName, backupPath, time.Now())
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		os.Remove(manifestPath(backupPath))
+		return fmt.Errorf("error creating pre-restore backup: %v", err)
+	}
+
+	fmt.Printf("Kept the replaced world as %s\n", backupPath)
 	return nil
 }
 
+// worldBackups returns a world's backups from the target in use, or the local backup directory
+func (bm *BackupManager) worldBackups(worldName string) ([]Backup, int64, error) {
+	if bm.remote != nil {
+		return bm.targetBackups(bm.remote, worldName)
+	}
+	return bm.getWorldBackups(filepath.Join(bm.BackupDir, worldName))
+}
+
 // Helper function to get backups for a specific world
 func (bm *BackupManager) getWorldBackups(worldBackupDir string) ([]Backup, int64, error) {
 	var backups []Backup
@@ -207,20 +551,50 @@ func (bm *BackupManager) getWorldBackups(worldBackupDir string) ([]Backup, int64
 	}
 
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".zip") {
+		if !entry.IsDir() && isArchiveName(entry.Name()) {
 			info, err := entry.Info()
 			if err != nil {
 				continue
 			}
 
-			backups = append(backups, Backup{
+			backup := Backup{
 				Name:      entry.Name(),
 				Path:      filepath.Join(worldBackupDir, entry.Name()),
 				Size:      info.Size(),
 				CreatedAt: info.ModTime(),
-			})
+			}
+
+			// Copying or restoring the file changes its mtime, the manifest keeps the real time
+			if manifest, err := readManifest(backup.Path); err == nil && manifest != nil {
+				backup.CreatedAt = manifest.CreatedAt
+				backup.Manifest = manifest
+			}
+
+			backups = append(backups, backup)
 			totalSize += info.Size()
 		}
+
+		// Repository snapshots count the size of the files they refer to
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), snapshotExt) {
+			path := filepath.Join(worldBackupDir, entry.Name())
+			snapshot, err := ReadSnapshot(path)
+			if err != nil {
+				continue
+			}
+
+			backup := Backup{
+				Name:      entry.Name(),
+				Path:      path,
+				Size:      snapshot.Size(),
+				CreatedAt: snapshot.CreatedAt,
+			}
+			if manifest, err := readManifest(path); err == nil {
+				backup.Manifest = manifest
+			}
+
+			backups = append(backups, backup)
+			totalSize += snapshot.Size()
+		}
 	}
 
 	return backups, totalSize, nil
@@ -228,31 +602,6 @@ func (bm *BackupManager) getWorldBackups(worldBackupDir string) ([]Backup, int64
 
 // Helper function to clean up old backups
 func (bm *BackupManager) cleanOldBackups(worldName string) error {
-	if bm.MaxBackups <= 0 {
-		return nil // Keep all backups
-	}
-
-	worldBackupDir := filepath.Join(bm.BackupDir, worldName)
-	backups, _, err := bm.getWorldBackups(worldBackupDir)
-	if err != nil {
-		return err
-	}
-
-	if len(backups) <= bm.MaxBackups {
-		return nil
-	}
-
-	// Sort by creation time (oldest first)
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].CreatedAt.Before(backups[j].CreatedAt)
-	})
-
-	// Remove oldest backups
-	for i := 0; i < len(backups)-bm.MaxBackups; i++ {
-		if err := os.Remove(backups[i].Path); err != nil {
-			return fmt.Errorf("error removing old backup %s: %v", backups[i].Name, err)
-		}
-	}
-
-	return nil
+	_, err := bm.PruneBackups(worldName, false)
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bsm/internal/server"
+	"bsm/utils"
+)
+
+// manifestExt is appended to a backup's file name to name its manifest
+const manifestExt = ".manifest.json"
+
+// Manifest is the sidecar file written next to every backup
+// It records what the backup should contain so it can be verified later
+type Manifest struct {
+	World         string         `json:"world"`
+	ServerVersion string         `json:"server_version,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Size          int64          `json:"size"`
+	ArchiveSize   int64          `json:"archive_size"`
+	Files         []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestPath(backupPath string) string {
+	return backupPath + manifestExt
+}
+
+// readManifest loads the manifest of a backup
+// Returns nil if the backup was made before manifests existed
+func readManifest(backupPath string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(backupPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// writeManifest reads a finished backup back and records its contents
+// Reading the backup rather than the world also proves the backup is readable
+func (bm *BackupManager) writeManifest(worldName, backupPath string, createdAt time.Time) error {
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return err
+	}
+
+	manifest := Manifest{
+		World:       worldName,
+		CreatedAt:   createdAt,
+		ArchiveSize: info.Size(),
+	}
+	if installed, err := server.ReadInstalledVersion(bm.ServerDir); err == nil && installed != nil {
+		manifest.ServerVersion = installed.Version
+	}
+
+	backup := Backup{Name: info.Name(), Path: backupPath}
+	manifest.Files, err = bm.hashBackup(backup)
+	if err != nil {
+		return fmt.Errorf("error reading back backup: %v", err)
+	}
+	for _, f := range manifest.Files {
+		manifest.Size += f.Size
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath(backupPath), data, 0644)
+}
+
+// hashBackup returns the size and SHA-256 of every file in a backup, sorted by path
+func (bm *BackupManager) hashBackup(backup Backup) ([]ManifestFile, error) {
+	var files []ManifestFile
+	err := bm.walkBackup(backup, func(name string, r io.Reader) error {
+		hash := sha256.New()
+		size, err := io.Copy(hash, r)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		files = append(files, ManifestFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// walkBackup calls fn with the contents of each regular file in a backup
+func (bm *BackupManager) walkBackup(backup Backup, fn func(name string, r io.Reader) error) error {
+	if strings.HasSuffix(backup.Name, snapshotExt) {
+		snapshot, err := ReadSnapshot(backup.Path)
+		if err != nil {
+			return err
+		}
+		repo := bm.repository()
+		for _, f := range snapshot.Files {
+			if err := fn(f.Path, &chunkReader{repo: repo, chunks: f.Chunks}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	archivePath, cleanup, err := bm.plainArchive(backup.Path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return utils.WalkArchive(archivePath, fn)
+}
+
+// chunkReader reads a repository file chunk by chunk
+type chunkReader struct {
+	repo   *Repository
+	chunks []string
+	buf    []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if len(c.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := c.repo.readBlob(c.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		c.buf, c.chunks = data, c.chunks[1:]
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// VerifyBackup re-reads a backup and compares it against its manifest
+// Backups without a manifest are only checked for being readable
+func (bm *BackupManager) VerifyBackup(backup Backup) error {
+	manifest, err := readManifest(backup.Path)
+	if err != nil {
+		return err
+	}
+
+	if manifest != nil {
+		info, err := os.Stat(backup.Path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != manifest.ArchiveSize {
+			return fmt.Errorf("truncated: archive is %d bytes, expected %d", info.Size(), manifest.ArchiveSize)
+		}
+	}
+
+	// Authenticating an encrypted archive proves it is exactly what was written,
+	// which is what the manifest was made from, without decrypting it to disk
+	if utils.IsEncrypted(backup.Path) {
+		key, err := bm.encryptionKey()
+		if err != nil {
+			return fmt.Errorf("backup is encrypted: %v", err)
+		}
+		if err := utils.AuthenticateFile(backup.Path, key); err != nil {
+			return fmt.Errorf("corrupted: %v", err)
+		}
+		return nil
+	}
+
+	files, err := bm.hashBackup(backup)
+	if err != nil {
+		return fmt.Errorf("unreadable: %v", err)
+	}
+	if manifest == nil {
+		return nil
+	}
+
+	expected := make(map[string]ManifestFile)
+	for _, f := range manifest.Files {
+		expected[f.Path] = f
+	}
+
+	var problems []string
+	for _, f := range files {
+		want, ok := expected[f.Path]
+		delete(expected, f.Path)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("unexpected file %s", f.Path))
+		case f.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("%s is %d bytes, expected %d", f.Path, f.Size, want.Size))
+		case f.SHA256 != want.SHA256:
+			problems = append(problems, fmt.Sprintf("%s checksum mismatch", f.Path))
+		}
+	}
+	for path := range expected {
+		problems = append(problems, fmt.Sprintf("missing file %s", path))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("corrupted: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package backup
+
+import (
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bsm/utils"
+)
+
+const (
+	// snapshotExt marks backups stored in the repository instead of an archive
+	snapshotExt = ".snapshot"
+
+	// repositoryChunkSize is the size of the pieces files are split into.
+	// LevelDB never rewrites its .ldb files and only appends to its log, so
+	// fixed size chunks already share everything that didn't change
+	repositoryChunkSize = 1 << 20
+
+	// repositoryGracePeriod keeps gc away from blobs a running backup has
+	// written or reused but not yet recorded in its snapshot
+	repositoryGracePeriod = time.Hour
+)
+
+// Snapshot is the manifest of a repository backup
+// File contents live in the repository as blobs named by their SHA-256
+type Snapshot struct {
+	World     string         `json:"world"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []SnapshotFile `json:"files"`
+}
+
+type SnapshotFile struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Size    int64       `json:"size"`
+	Chunks  []string    `json:"chunks"`
+}
+
+// Size returns the combined size of the files in the snapshot
+func (s *Snapshot) Size() int64 {
+	var total int64
+	for _, f := range s.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Repository is a content-addressed blob store shared by all snapshots
+type Repository struct {
+	Dir string
+}
+
+func (bm *BackupManager) repository() *Repository {
+	return &Repository{Dir: filepath.Join(bm.BackupDir, ".repository")}
+}
+
+func (r *Repository) blobPath(id string) string {
+	return filepath.Join(r.Dir, "blobs", id[:2], id)
+}
+
+// WriteSnapshot stores the first Size bytes of each file under src as blobs
+// and writes the snapshot manifest to dst
+func (r *Repository) WriteSnapshot(worldName, src string, files []utils.TruncatedFile, dst string) error {
+	snapshot := Snapshot{World: worldName, CreatedAt: time.Now()}
+
+	for _, f := range files {
+		path := f.SourcePath(src)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() < f.Size {
+			return fmt.Errorf("%s is shorter than expected (%d < %d bytes)", f.Path, info.Size(), f.Size)
+		}
+
+		chunks, err := r.storeFile(path, f.Size)
+		if err != nil {
+			return fmt.Errorf("error storing %s: %v", f.Path, err)
+		}
+
+		snapshot.Files = append(snapshot.Files, SnapshotFile{
+			Path:    f.Path,
+			Mode:    info.Mode().Perm(),
+			ModTime: info.ModTime(),
+			Size:    f.Size,
+			Chunks:  chunks,
+		})
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The manifest is what makes a snapshot exist, so it only appears once complete
+	tmpPath := dst + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// storeFile splits the first size bytes of a file into blobs and returns their ids
+func (r *Repository) storeFile(path string, size int64) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := io.LimitReader(file, size)
+	buf := make([]byte, repositoryChunkSize)
+	var chunks []string
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			id, storeErr := r.storeBlob(buf[:n])
+			if storeErr != nil {
+				return nil, storeErr
+			}
+			chunks = append(chunks, id)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// storeBlob writes a compressed chunk unless the repository already has it
+func (r *Repository) storeBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	path := r.blobPath(id)
+
+	if _, err := os.Stat(path); err == nil {
+		// Mark the blob as recently used so gc leaves it alone until the snapshot is written
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return id, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), id+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer, err := flate.NewWriter(tmp, flate.DefaultCompression)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := writer.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return id, os.Rename(tmp.Name(), path)
+}
+
+// readBlob returns the contents of a blob after checking them against its id
+func (r *Repository) readBlob(id string) ([]byte, error) {
+	if len(id) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid blob id %q", id)
+	}
+
+	file, err := os.Open(r.blobPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("missing blob %s: %v", id, err)
+	}
+	defer file.Close()
+
+	reader := flate.NewReader(file)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s is corrupted: %v", id, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("blob %s is corrupted: checksum mismatch", id)
+	}
+	return data, nil
+}
+
+// ReadSnapshot loads a snapshot manifest
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %s: %v", filepath.Base(path), err)
+	}
+	return &snapshot, nil
+}
+
+// Restore writes the files of a snapshot to dest
+// Every path is checked before anything is written, like ExtractZip does
+func (r *Repository) Restore(snapshotPath, dest string) error {
+	snapshot, err := ReadSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range snapshot.Files {
+		if _, err := utils.SafeJoin(dest, f.Path); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range snapshot.Files {
+		path, _ := utils.SafeJoin(dest, f.Path)
+		if err := r.restoreFile(f, path); err != nil {
+			return fmt.Errorf("error restoring %s: %v", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) restoreFile(f SnapshotFile, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	perm := f.Mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	for _, id := range f.Chunks {
+		data, err := r.readBlob(id)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			out.Close()
+			return err
+		}
+		written += int64(len(data))
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if written != f.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", f.Size, written)
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		return err
+	}
+	return os.Chtimes(path, f.ModTime, f.ModTime)
+}
+
+// CollectGarbage deletes repository blobs that no snapshot of any world refers to
+// Blobs touched within repositoryGracePeriod are kept for backups still in progress.
+// Returns how many blobs were (or with dryRun would be) deleted and their size
+func (bm *BackupManager) CollectGarbage(dryRun bool) (int, int64, error) {
+	repo := bm.repository()
+	blobsDir := filepath.Join(repo.Dir, "blobs")
+	if _, err := os.Stat(blobsDir); os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+
+	worldNames, err := bm.BackupWorlds()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Mark every blob referenced by a snapshot. A snapshot that can't be read
+	// could refer to anything, so stop rather than delete blobs it needs
+	used := make(map[string]bool)
+	for _, worldName := range worldNames {
+		entries, err := os.ReadDir(filepath.Join(bm.BackupDir, worldName))
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotExt) {
+				continue
+			}
+			snapshot, err := ReadSnapshot(filepath.Join(bm.BackupDir, worldName, entry.Name()))
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, f := range snapshot.Files {
+				for _, id := range f.Chunks {
+					used[id] = true
+				}
+			}
+		}
+	}
+
+	var removed int
+	var freed int64
+	cutoff := time.Now().Add(-repositoryGracePeriod)
+	err = filepath.Walk(blobsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || used[info.Name()] || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		removed++
+		freed += info.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+
+	return removed, freed, err
+}
@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bsm/internal/config"
+)
+
+// PruneDecision records whether a backup survives the retention policy and why
+type PruneDecision struct {
+	Backup  Backup
+	Keep    bool
+	Reasons []string
+}
+
+// retentionRule keeps the newest backup of each of the last Count periods
+type retentionRule struct {
+	Name   string
+	Count  int
+	Period func(t time.Time) string
+}
+
+// retentionPolicy returns the policy to prune with
+// backups_to_keep stands in for keep_last when no keep_ setting is used
+func (bm *BackupManager) retentionPolicy() config.RetentionPolicy {
+	policy := bm.Retention
+	if !policy.HasKeepRules() {
+		policy.KeepLast = bm.MaxBackups
+	}
+	return policy
+}
+
+// planRetention decides which backups to keep, newest first
+func planRetention(backups []Backup, policy config.RetentionPolicy) []PruneDecision {
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	decisions := make([]PruneDecision, len(backups))
+	for i, backup := range backups {
+		decisions[i].Backup = backup
+	}
+
+	// Nothing to enforce, keep everything
+	if !policy.HasKeepRules() && policy.MaxTotalSize <= 0 {
+		for i := range decisions {
+			decisions[i].Keep = true
+			decisions[i].Reasons = []string{"no retention limits"}
+		}
+		return decisions
+	}
+
+	if policy.HasKeepRules() {
+		for i := range decisions {
+			if i < policy.KeepLast {
+				keep(&decisions[i], "last")
+			}
+		}
+
+		rules := []retentionRule{
+			{"hourly", policy.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+			{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+			{"weekly", policy.KeepWeekly, func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			}},
+			{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		}
+		for _, rule := range rules {
+			remaining := rule.Count
+			lastPeriod := ""
+			for i := range decisions {
+				if remaining <= 0 {
+					break
+				}
+				// Backups are newest first, so the first one seen in a period is its newest
+				period := rule.Period(decisions[i].Backup.CreatedAt.Local())
+				if period == lastPeriod {
+					continue
+				}
+				lastPeriod = period
+				keep(&decisions[i], rule.Name)
+				remaining--
+			}
+		}
+
+		for i := range decisions {
+			if !decisions[i].Keep {
+				decisions[i].Reasons = []string{"not selected by any retention rule"}
+			}
+		}
+	} else {
+		for i := range decisions {
+			keep(&decisions[i], "within max_total_size")
+		}
+	}
+
+	if policy.MaxTotalSize > 0 {
+		limit := int64(policy.MaxTotalSize) * 1024 * 1024
+		var total int64
+		over := false
+		for i := range decisions {
+			if !decisions[i].Keep {
+				continue
+			}
+			total += decisions[i].Backup.Size
+			// The newest backup is always kept, however large it is.
+			// Once the limit is hit every older backup goes too
+			if total > limit && i > 0 {
+				over = true
+			}
+			if over {
+				decisions[i].Keep = false
+				decisions[i].Reasons = []string{fmt.Sprintf("over max_total_size of %d MB", policy.MaxTotalSize)}
+			}
+		}
+	}
+
+	return decisions
+}
+
+func keep(decision *PruneDecision, reason string) {
+	decision.Keep = true
+	decision.Reasons = append(decision.Reasons, reason)
+}
+
+// PruneBackups applies the retention policy to a world's backups
+// With dryRun set nothing is deleted, the decisions are only reported
+func (bm *BackupManager) PruneBackups(worldName string, dryRun bool) ([]PruneDecision, error) {
+	if bm.remote != nil {
+		return bm.pruneTarget(bm.remote, worldName, dryRun)
+	}
+
+	worldBackupDir := filepath.Join(bm.BackupDir, worldName)
+	backups, _, err := bm.getWorldBackups(worldBackupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := planRetention(backups, bm.retentionPolicy())
+	if dryRun {
+		return decisions, nil
+	}
+
+	removedSnapshots := false
+	for _, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		if err := os.Remove(decision.Backup.Path); err != nil {
+			return decisions, fmt.Errorf("error removing old backup %s: %v", decision.Backup.Name, err)
+		}
+		os.Remove(manifestPath(decision.Backup.Path))
+		if strings.HasSuffix(decision.Backup.Name, snapshotExt) {
+			removedSnapshots = true
+		}
+	}
+
+	// Free the repository blobs only the deleted snapshots used
+	if removedSnapshots {
+		if _, _, err := bm.CollectGarbage(false); err != nil {
+			return decisions, fmt.Errorf("error collecting repository garbage: %v", err)
+		}
+	}
+
+	return decisions, nil
+}
+
+// BackupWorlds returns the names of all worlds that have a backup directory
+func (bm *BackupManager) BackupWorlds() ([]string, error) {
+	if bm.remote != nil {
+		return bm.targetWorlds(bm.remote)
+	}
+
+	entries, err := os.ReadDir(bm.BackupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading backup directory: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bsm/internal/config"
+)
+
+// retentionBackup is a backup made at a local time, with its size in MB
+type retentionBackup struct {
+	at   string
+	size int64
+}
+
+func TestPlanRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  config.RetentionPolicy
+		backups []retentionBackup
+		want    []string // Decisions newest first
+	}{
+		{
+			name:    "no limits keeps everything",
+			backups: []retentionBackup{{"2024-01-01 10:00", 1}, {"2024-01-02 10:00", 1}},
+			want: []string{
+				"2024-01-02 10:00 kept (no retention limits)",
+				"2024-01-01 10:00 kept (no retention limits)",
+			},
+		},
+		{
+			name:   "keep_last",
+			policy: config.RetentionPolicy{KeepLast: 2},
+			// Out of order, planning sorts them
+			backups: []retentionBackup{{"2024-01-02 10:00", 1}, {"2024-01-03 10:00", 1}, {"2024-01-01 10:00", 1}},
+			want: []string{
+				"2024-01-03 10:00 kept (last)",
+				"2024-01-02 10:00 kept (last)",
+				"2024-01-01 10:00 pruned (not selected by any retention rule)",
+			},
+		},
+		{
+			name:    "hourly keeps the newest in each hour",
+			policy:  config.RetentionPolicy{KeepHourly: 2},
+			backups: []retentionBackup{{"2024-01-01 10:05", 1}, {"2024-01-01 10:40", 1}, {"2024-01-01 11:10", 1}, {"2024-01-01 11:50", 1}, {"2024-01-01 12:30", 1}},
+			want: []string{
+				"2024-01-01 12:30 kept (hourly)",
+				"2024-01-01 11:50 kept (hourly)",
+				"2024-01-01 11:10 pruned (not selected by any retention rule)",
+				"2024-01-01 10:40 pruned (not selected by any retention rule)",
+				"2024-01-01 10:05 pruned (not selected by any retention rule)",
+			},
+		},
+		{
+			name:    "keep_last combined with daily",
+			policy:  config.RetentionPolicy{KeepLast: 1, KeepDaily: 3},
+			backups: []retentionBackup{{"2024-01-01 09:00", 1}, {"2024-01-01 18:00", 1}, {"2024-01-02 08:00", 1}, {"2024-01-02 20:00", 1}, {"2024-01-03 12:00", 1}},
+			want: []string{
+				"2024-01-03 12:00 kept (last, daily)",
+				"2024-01-02 20:00 kept (daily)",
+				"2024-01-02 08:00 pruned (not selected by any retention rule)",
+				"2024-01-01 18:00 kept (daily)",
+				"2024-01-01 09:00 pruned (not selected by any retention rule)",
+			},
+		},
+		{
+			// 2024-01-01 is a Monday, weeks run Monday to Sunday
+			name:    "weekly keeps the newest in each ISO week",
+			policy:  config.RetentionPolicy{KeepLast: 1, KeepWeekly: 2},
+			backups: []retentionBackup{{"2024-01-01 12:00", 1}, {"2024-01-07 12:00", 1}, {"2024-01-08 12:00", 1}, {"2024-01-10 12:00", 1}, {"2024-01-15 12:00", 1}},
+			want: []string{
+				"2024-01-15 12:00 kept (last, weekly)",
+				"2024-01-10 12:00 kept (weekly)",
+				"2024-01-08 12:00 pruned (not selected by any retention rule)",
+				"2024-01-07 12:00 pruned (not selected by any retention rule)",
+				"2024-01-01 12:00 pruned (not selected by any retention rule)",
+			},
+		},
+		{
+			name:    "monthly combined with hourly and weekly",
+			policy:  config.RetentionPolicy{KeepHourly: 1, KeepWeekly: 1, KeepMonthly: 3},
+			backups: []retentionBackup{{"2024-01-05 12:00", 1}, {"2024-01-30 12:00", 1}, {"2024-02-10 12:00", 1}, {"2024-02-20 12:00", 1}, {"2024-03-03 12:00", 1}},
+			want: []string{
+				"2024-03-03 12:00 kept (hourly, weekly, monthly)",
+				"2024-02-20 12:00 kept (monthly)",
+				"2024-02-10 12:00 pruned (not selected by any retention rule)",
+				"2024-01-30 12:00 kept (monthly)",
+				"2024-01-05 12:00 pruned (not selected by any retention rule)",
+			},
+		},
+		{
+			name:    "everything older goes once over max_total_size",
+			policy:  config.RetentionPolicy{KeepLast: 10, MaxTotalSize: 2},
+			backups: []retentionBackup{{"2024-01-03 10:00", 1}, {"2024-01-02 10:00", 2}, {"2024-01-01 10:00", 1}},
+			want: []string{
+				"2024-01-03 10:00 kept (last)",
+				"2024-01-02 10:00 pruned (over max_total_size of 2 MB)",
+				// Would still fit, but is older than a backup that didn't
+				"2024-01-01 10:00 pruned (over max_total_size of 2 MB)",
+			},
+		},
+		{
+			name:    "newest always kept even over max_total_size",
+			policy:  config.RetentionPolicy{MaxTotalSize: 2},
+			backups: []retentionBackup{{"2024-01-02 10:00", 5}, {"2024-01-01 10:00", 1}},
+			want: []string{
+				"2024-01-02 10:00 kept (within max_total_size)",
+				"2024-01-01 10:00 pruned (over max_total_size of 2 MB)",
+			},
+		},
+		{
+			name:    "pruned backups don't count towards max_total_size",
+			policy:  config.RetentionPolicy{KeepDaily: 2, MaxTotalSize: 2},
+			backups: []retentionBackup{{"2024-01-02 18:00", 1}, {"2024-01-02 08:00", 5}, {"2024-01-01 12:00", 1}},
+			want: []string{
+				"2024-01-02 18:00 kept (daily)",
+				"2024-01-02 08:00 pruned (not selected by any retention rule)",
+				"2024-01-01 12:00 kept (daily)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backups []Backup
+			for _, b := range tt.backups {
+				createdAt, err := time.ParseInLocation("2006-01-02 15:04", b.at, time.Local)
+				if err != nil {
+					t.Fatal(err)
+				}
+				backups = append(backups, Backup{Name: b.at, CreatedAt: createdAt, Size: b.size * 1024 * 1024})
+			}
+
+			var got []string
+			for _, d := range planRetention(backups, tt.policy) {
+				verdict := "pruned"
+				if d.Keep {
+					verdict = "kept"
+				}
+				got = append(got, fmt.Sprintf("%s %s (%s)", d.Backup.Name, verdict, strings.Join(d.Reasons, ", ")))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got decisions\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bsm/internal/logs"
+	"bsm/internal/server"
+)
+
+// ScheduleState is what the scheduler remembers between runs
+// LastSuccess only advances when a backup is created, so activity after a
+// failed run still gets backed up
+type ScheduleState struct {
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	LastResult  string    `json:"last_result"`
+}
+
+func (bm *BackupManager) scheduleStateFile() string {
+	return filepath.Join(bm.BackupDir, "schedule.json")
+}
+
+func (bm *BackupManager) scheduleLogFile() string {
+	return filepath.Join(bm.BackupDir, "schedule.log")
+}
+
+// RunSchedule backs up the active world every interval until stop is closed
+// A run that was due while the scheduler wasn't running happens right away
+func (bm *BackupManager) RunSchedule(interval time.Duration, stop <-chan struct{}) error {
+	if interval <= 0 {
+		return fmt.Errorf("backup_interval is 0, scheduled backups are disabled")
+	}
+
+	state, err := bm.readScheduleState()
+	if err != nil {
+		return err
+	}
+
+	for {
+		next := state.LastRun.Add(interval)
+		if wait := time.Until(next); wait > 0 {
+			fmt.Printf("Next backup at %s\n", next.Format("2006-01-02 15:04:05"))
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(wait):
+			}
+		} else if !state.LastRun.IsZero() {
+			fmt.Printf("Backup was due at %s, running now\n", next.Format("2006-01-02 15:04:05"))
+		}
+
+		state = bm.runScheduled(state)
+		if err := bm.writeScheduleState(state); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// runScheduled performs one scheduled run and returns the new schedule state
+func (bm *BackupManager) runScheduled(state *ScheduleState) *ScheduleState {
+	now := time.Now()
+	result := "backup created"
+	lastSuccess := state.LastSuccess
+
+	worldName, err := bm.worlds.GetActiveWorld()
+	switch {
+	case err != nil:
+		result = fmt.Sprintf("failed: %v", err)
+	case !state.LastSuccess.IsZero() && !bm.playersActiveSince(state.LastSuccess):
+		result = "skipped, no players online since the last backup"
+	default:
+		if err := bm.CreateBackup(worldName); err != nil {
+			result = fmt.Sprintf("failed: %v", err)
+		} else {
+			lastSuccess = now
+		}
+	}
+
+	bm.logScheduleRun(now, worldName, result)
+	return &ScheduleState{LastRun: now, LastSuccess: lastSuccess, LastResult: result}
+}
+
+// playersActiveSince reports whether any player was online after the given time
+func (bm *BackupManager) playersActiveSince(since time.Time) bool {
+	status, err := bm.server.StatusInfo()
+	if err == nil && status.PlayersOnline > 0 {
+		return true
+	}
+
+	// Anyone joining or leaving since then shows up in the server log
+	counter := &lineCounter{}
+	filter := logs.Filter{Since: since, Pattern: server.PlayerActivityPattern}
+	if err := logs.Read(server.LogDirectory(bm.ServerDir), filter, counter); err != nil {
+		// Can't tell, so back up rather than risk missing changes
+		return true
+	}
+	return counter.lines > 0
+}
+
+// logScheduleRun reports the result of a run on stdout and in the schedule log
+func (bm *BackupManager) logScheduleRun(at time.Time, worldName, result string) {
+	line := fmt.Sprintf("%s %s: %s", at.Format(time.RFC3339), worldName, result)
+	fmt.Println(line)
+
+	if err := os.MkdirAll(bm.BackupDir, 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(bm.scheduleLogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Printf("Warning: error opening schedule log: %v\n", err)
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+func (bm *BackupManager) readScheduleState() (*ScheduleState, error) {
+	data, err := os.ReadFile(bm.scheduleStateFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ScheduleState{}, nil
+		}
+		return nil, fmt.Errorf("error reading schedule state: %v", err)
+	}
+
+	var state ScheduleState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing schedule state: %v", err)
+	}
+	return &state, nil
+}
+
+func (bm *BackupManager) writeScheduleState(state *ScheduleState) error {
+	if err := os.MkdirAll(bm.BackupDir, 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling schedule state: %v", err)
+	}
+	if err := os.WriteFile(bm.scheduleStateFile(), data, 0644); err != nil {
+		return fmt.Errorf("error writing schedule state: %v", err)
+	}
+	return nil
+}
+
+// lineCounter counts the lines written to it
+type lineCounter struct {
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			c.lines++
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"bsm/internal/config"
+)
+
+// sftpTarget stores backups on a remote server over SFTP
+// The server's host key must be listed in known_hosts. One connection is opened
+// on first use and shared by every operation until Close
+type sftpTarget struct {
+	cfg    config.BackupTargetConfig
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPTarget(cfg config.BackupTargetConfig) *sftpTarget {
+	return &sftpTarget{cfg: cfg}
+}
+
+func (t *sftpTarget) Name() string {
+	return t.cfg.Name
+}
+
+// connect returns the target's SFTP session, opening it if needed
+func (t *sftpTarget) connect() (*sftp.Client, error) {
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	knownHostsPath := t.cfg.KnownHosts
+	if knownHostsPath == "" {
+		knownHostsPath = "~/.ssh/known_hosts"
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsPath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading known_hosts: %v", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if t.cfg.KeyFile != "" {
+		key, err := os.ReadFile(expandHome(t.cfg.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading key_file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key_file: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if t.cfg.Password != "" {
+		auth = append(auth, ssh.Password(t.cfg.Password))
+	}
+
+	host := t.cfg.Host
+	if !strings.Contains(host, ":") {
+		host += ":22"
+	}
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            t.cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting sftp session: %v", err)
+	}
+	t.conn, t.client = conn, client
+	return client, nil
+}
+
+// Close ends the SFTP session and the SSH connection under it
+func (t *sftpTarget) Close() error {
+	if t.client == nil {
+		return nil
+	}
+	t.client.Close()
+	err := t.conn.Close()
+	t.conn, t.client = nil, nil
+	return err
+}
+
+func (t *sftpTarget) remotePath(key string) string {
+	return path.Join(t.cfg.Path, key)
+}
+
+func (t *sftpTarget) Upload(localPath, key string) error {
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst := t.remotePath(key)
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return err
+	}
+
+	// Upload next to the destination first so a half written file never looks like a backup
+	tmpPath := dst + ".part"
+	out, err := client.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := out.ReadFrom(src); err != nil {
+		out.Close()
+		client.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		client.Remove(tmpPath)
+		return err
+	}
+	return client.PosixRename(tmpPath, dst)
+}
+
+func (t *sftpTarget) Download(key string, w io.Writer) error {
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	file, err := client.Open(t.remotePath(key))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteTo(w)
+	return err
+}
+
+func (t *sftpTarget) List(dir string) ([]RemoteObject, error) {
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := client.ReadDir(t.remotePath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var objects []RemoteObject
+	for _, info := range infos {
+		objects = append(objects, RemoteObject{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+	return objects, nil
+}
+
+func (t *sftpTarget) Delete(key string) error {
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	return client.Remove(t.remotePath(key))
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, strings.TrimPrefix(p, "~"))
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"bsm/internal/config"
+)
+
+// fakeSFTPServer serves the local filesystem over SFTP to a single user
+type fakeSFTPServer struct {
+	addr        string
+	hostKey     ssh.Signer
+	connections atomic.Int32
+}
+
+func startFakeSFTPServer(t *testing.T, user, password string) *fakeSFTPServer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if conn.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeSFTPServer{addr: listener.Addr().String(), hostKey: hostKey}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, serverConfig)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSFTPServer) serve(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	s.connections.Add(1)
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				server.Close()
+				return
+			}
+		}()
+	}
+}
+
+// writeKnownHosts writes a known_hosts file trusting key for the server's address
+func writeKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSFTPTarget(t *testing.T) {
+	server := startFakeSFTPServer(t, "bsm", "secret")
+	root := t.TempDir()
+
+	target, err := NewBackupTarget(config.BackupTargetConfig{
+		Name:       "remote",
+		Type:       "sftp",
+		Host:       server.addr,
+		User:       "bsm",
+		Password:   "secret",
+		Path:       root,
+		KnownHosts: writeKnownHosts(t, server.addr, server.hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	local := filepath.Join(t.TempDir(), "backup.zip")
+	if err := os.WriteFile(local, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"world/a.zip", "world/b.zip", "other/c.zip"} {
+		if err := target.Upload(local, key); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "world", "a.zip.part")); !os.IsNotExist(err) {
+		t.Error("upload left its .part file behind")
+	}
+
+	worlds, err := target.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worlds) != 2 || !worlds[0].IsDir || !worlds[1].IsDir {
+		t.Errorf("listing the root returned %+v, want the two world directories", worlds)
+	}
+
+	objects, err := target.List("world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Size != int64(len("archive")) {
+		t.Errorf("listing world returned %+v, want a.zip and b.zip", objects)
+	}
+
+	missing, err := target.List("missing")
+	if err != nil || len(missing) != 0 {
+		t.Errorf("listing a missing directory returned %v, %v", missing, err)
+	}
+
+	var buf bytes.Buffer
+	if err := target.Download("world/a.zip", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "archive" {
+		t.Errorf("downloaded %q, want %q", buf.String(), "archive")
+	}
+
+	if err := target.Delete("world/a.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "world", "a.zip")); !os.IsNotExist(err) {
+		t.Error("deleted backup still exists")
+	}
+
+	if n := server.connections.Load(); n != 1 {
+		t.Errorf("opened %d connections, want every operation to share one", n)
+	}
+
+	// Closing drops the connection, the next operation opens a new one
+	if err := target.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.List("world"); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.connections.Load(); n != 2 {
+		t.Errorf("opened %d connections after reconnecting, want 2", n)
+	}
+}
+
+func TestSFTPTargetRejectsUnknownHostKey(t *testing.T) {
+	server := startFakeSFTPServer(t, "bsm", "secret")
+
+	other, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := NewBackupTarget(config.BackupTargetConfig{
+		Name:       "remote",
+		Type:       "sftp",
+		Host:       server.addr,
+		User:       "bsm",
+		Password:   "secret",
+		Path:       t.TempDir(),
+		KnownHosts: writeKnownHosts(t, server.addr, other.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	if _, err := target.List(""); err == nil {
+		t.Fatal("expected a host key mismatch to be rejected")
+	}
+}
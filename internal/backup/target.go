@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bsm/internal/config"
+	"bsm/utils"
+)
+
+// BackupTarget is a place backups are copied to, such as another disk or a remote server
+// Keys are slash separated paths relative to the target's root, laid out like
+// the local backup directory: {world}/{backup file}
+type BackupTarget interface {
+	Name() string
+	// Upload copies a local file to key, replacing anything already there
+	Upload(localPath, key string) error
+	// Download writes the contents of key to w
+	Download(key string, w io.Writer) error
+	// List returns the objects and directories directly inside dir, "" for the root
+	List(dir string) ([]RemoteObject, error)
+	Delete(key string) error
+	// Close releases any connection the target keeps open between operations
+	Close() error
+}
+
+// RemoteObject is an entry returned by BackupTarget.List
+type RemoteObject struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// NewBackupTarget creates the target described by a backup_targets entry
+func NewBackupTarget(cfg config.BackupTargetConfig) (BackupTarget, error) {
+	switch cfg.Type {
+	case "local":
+		return &localTarget{name: cfg.Name, dir: cfg.Path}, nil
+	case "sftp":
+		return newSFTPTarget(cfg), nil
+	case "s3":
+		return newS3Target(cfg)
+	}
+	return nil, fmt.Errorf("unknown backup target type %q", cfg.Type)
+}
+
+// localTarget copies backups to a directory, usually on another disk or a network mount
+type localTarget struct {
+	name string
+	dir  string
+}
+
+func (t *localTarget) Name() string {
+	return t.name
+}
+
+func (t *localTarget) path(key string) (string, error) {
+	return utils.SafeJoin(t.dir, filepath.FromSlash(key))
+}
+
+func (t *localTarget) Upload(localPath, key string) error {
+	dst, err := t.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// Copy next to the destination first so a half written file never looks like a backup
+	tmpPath := dst + ".part"
+	if err := utils.CopyFile(localPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func (t *localTarget) Download(key string, w io.Writer) error {
+	src, err := t.path(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func (t *localTarget) List(dir string) ([]RemoteObject, error) {
+	src, err := t.path(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var objects []RemoteObject
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, RemoteObject{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   entry.IsDir(),
+		})
+	}
+	return objects, nil
+}
+
+func (t *localTarget) Delete(key string) error {
+	dst, err := t.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(dst)
+}
+
+func (t *localTarget) Close() error {
+	return nil
+}
+
+// targetKey returns the key a world's backup file is stored under on a target
+func targetKey(worldName, name string) string {
+	return path.Join(worldName, name)
+}
+
+// isArchiveName reports whether a file name is a self-contained backup archive
+func isArchiveName(name string) bool {
+	name = strings.TrimSuffix(name, encryptedExt)
+	for _, format := range utils.ArchiveFormats {
+		if strings.HasSuffix(name, "."+format) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,438 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"bsm/internal/config"
+	"bsm/utils"
+)
+
+// uploadsDir holds one empty marker file per pending upload: {target}/{world}/{backup file}
+func (bm *BackupManager) uploadsDir() string {
+	return filepath.Join(bm.BackupDir, ".uploads")
+}
+
+// queueUploads marks a new backup for upload to every configured target
+func (bm *BackupManager) queueUploads(worldName, backupName string) error {
+	for _, target := range bm.Targets {
+		marker := filepath.Join(bm.uploadsDir(), target.Name, worldName, backupName)
+		if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(marker, nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startUploader runs `bsm backup upload` in the background so CreateBackup
+// doesn't wait for the network. It inherits the working directory so it
+// reads the same config file
+func (bm *BackupManager) startUploader() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate bsm executable: %v", err)
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(bm.BackupDir, "upload.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open upload log: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "backup", "upload")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Detach from the terminal so the upload outlives this command
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start uploader: %v", err)
+	}
+	return cmd.Process.Release()
+}
+
+// ProcessUploads uploads every queued backup and applies each target's retention
+// Failed uploads stay queued for the next run. Only one process uploads at a
+// time, a second one returns right away and leaves the queue to the first
+func (bm *BackupManager) ProcessUploads() error {
+	if err := os.MkdirAll(bm.uploadsDir(), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(bm.uploadsDir(), "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fmt.Println("Another upload is already running")
+		return nil
+	}
+
+	// Keep going until a pass finds nothing new, backups may be queued meanwhile
+	attempted := make(map[string]bool)
+	failed := 0
+	for {
+		markers, err := bm.pendingUploads()
+		if err != nil {
+			return err
+		}
+
+		progress := false
+		for _, marker := range markers {
+			if attempted[marker] {
+				continue
+			}
+			attempted[marker] = true
+			progress = true
+
+			if err := bm.processUpload(marker); err != nil {
+				fmt.Printf("%s Upload of %s failed: %v\n", time.Now().Format(time.RFC3339), marker, err)
+				failed++
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d uploads failed and will be retried", failed)
+	}
+	return nil
+}
+
+// pendingUploads lists queued uploads as {target}/{world}/{backup file}
+// Snapshots being exported by uploadBackup share the directory and are skipped
+func (bm *BackupManager) pendingUploads() ([]string, error) {
+	var markers []string
+	err := filepath.Walk(bm.uploadsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bm.uploadsDir(), path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filepath.Dir(rel) == "." && strings.HasPrefix(rel, "export-") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) == 3 && isMarkerName(parts[2]) {
+			markers = append(markers, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(markers)
+	return markers, err
+}
+
+// isMarkerName reports whether a file in the upload queue names a backup
+func isMarkerName(name string) bool {
+	return isArchiveName(name) || strings.HasSuffix(name, snapshotExt)
+}
+
+// processUpload uploads one queued backup and removes it from the queue
+func (bm *BackupManager) processUpload(marker string) error {
+	parts := strings.SplitN(marker, "/", 3)
+	targetName, worldName, backupName := parts[0], parts[1], parts[2]
+	markerPath := filepath.Join(bm.uploadsDir(), filepath.FromSlash(marker))
+
+	if _, ok := bm.targetConfig(targetName); !ok {
+		fmt.Printf("Dropping upload of %s, target %s is no longer configured\n", backupName, targetName)
+		return os.Remove(markerPath)
+	}
+	target, err := bm.target(targetName)
+	if err != nil {
+		return err
+	}
+
+	backupPath := filepath.Join(bm.BackupDir, worldName, backupName)
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		fmt.Printf("Dropping upload of %s, the backup no longer exists\n", backupName)
+		return os.Remove(markerPath)
+	}
+
+	fmt.Printf("%s Uploading %s to %s...\n", time.Now().Format(time.RFC3339), backupName, targetName)
+	if err := bm.uploadBackup(target, worldName, Backup{Name: backupName, Path: backupPath}); err != nil {
+		return err
+	}
+	if err := os.Remove(markerPath); err != nil {
+		return err
+	}
+
+	if _, err := bm.pruneTarget(target, worldName, false); err != nil {
+		fmt.Printf("Warning: error pruning backups on %s: %v\n", targetName, err)
+	}
+	return nil
+}
+
+// uploadBackup copies a backup and its manifest to a target
+// Targets only hold self-contained archives, so repository snapshots are
+// exported to an archive in the configured format first. The manifest is
+// uploaded as is, unencrypted, so backups can be listed without the key
+func (bm *BackupManager) uploadBackup(target BackupTarget, worldName string, backup Backup) error {
+	archivePath, archiveName := backup.Path, backup.Name
+	if strings.HasSuffix(backup.Name, snapshotExt) {
+		tmpDir, err := os.MkdirTemp(bm.uploadsDir(), "export-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		if err := bm.repository().Restore(backup.Path, filepath.Join(tmpDir, "world")); err != nil {
+			return fmt.Errorf("error exporting snapshot: %v", err)
+		}
+		archiveName = backupBaseName(backup.Name) + bm.archiveExt()
+		archivePath = filepath.Join(tmpDir, archiveName)
+
+		out, err := bm.createArchive(archivePath)
+		if err != nil {
+			return err
+		}
+		err = utils.WriteArchive(out, filepath.Join(tmpDir, "world"), nil, bm.Format, bm.Level)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("error exporting snapshot: %v", err)
+		}
+	}
+
+	if err := target.Upload(archivePath, targetKey(worldName, archiveName)); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(backup.Path)
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	// The manifest describes the archive that was uploaded, not the local snapshot
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+	manifest.ArchiveSize = info.Size()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpManifest, err := os.CreateTemp(bm.uploadsDir(), "manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpManifest.Name())
+	_, err = tmpManifest.Write(data)
+	tmpManifest.Close()
+	if err != nil {
+		return err
+	}
+
+	return target.Upload(tmpManifest.Name(), targetKey(worldName, archiveName+manifestExt))
+}
+
+func (bm *BackupManager) targetConfig(name string) (config.BackupTargetConfig, bool) {
+	for _, t := range bm.Targets {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return config.BackupTargetConfig{}, false
+}
+
+// target returns the configured target with the given name
+// Each target is opened once and reused until Close, so connections are shared
+func (bm *BackupManager) target(name string) (BackupTarget, error) {
+	if target, ok := bm.targets[name]; ok {
+		return target, nil
+	}
+	targetCfg, ok := bm.targetConfig(name)
+	if !ok {
+		return nil, fmt.Errorf("no backup target named '%s' in backup_targets", name)
+	}
+	target, err := NewBackupTarget(targetCfg)
+	if err != nil {
+		return nil, err
+	}
+	if bm.targets == nil {
+		bm.targets = make(map[string]BackupTarget)
+	}
+	bm.targets[name] = target
+	return target, nil
+}
+
+// Close closes the connections of every target used
+func (bm *BackupManager) Close() error {
+	var firstErr error
+	for name, target := range bm.targets {
+		if err := target.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error closing %s: %v", name, err)
+		}
+	}
+	bm.targets = nil
+	return firstErr
+}
+
+// UseTarget makes listing, restoring and pruning work on a backup target
+// instead of the local backup directory
+func (bm *BackupManager) UseTarget(name string) error {
+	target, err := bm.target(name)
+	if err != nil {
+		return err
+	}
+	bm.remote = target
+	return nil
+}
+
+// targetWorlds returns the names of all worlds with backups on a target
+func (bm *BackupManager) targetWorlds(target BackupTarget) ([]string, error) {
+	objects, err := target.List("")
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", target.Name(), err)
+	}
+
+	var names []string
+	for _, o := range objects {
+		if o.IsDir && !strings.HasPrefix(o.Name, ".") {
+			names = append(names, o.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// targetBackups returns a world's backups on a target, with their manifests
+func (bm *BackupManager) targetBackups(target BackupTarget, worldName string) ([]Backup, int64, error) {
+	objects, err := target.List(worldName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing %s: %v", target.Name(), err)
+	}
+
+	var backups []Backup
+	var totalSize int64
+	for _, o := range objects {
+		if o.IsDir || !isArchiveName(o.Name) {
+			continue
+		}
+
+		backup := Backup{
+			Name:      o.Name,
+			Path:      targetKey(worldName, o.Name),
+			Size:      o.Size,
+			CreatedAt: o.ModTime,
+			Target:    target.Name(),
+		}
+
+		var buf bytes.Buffer
+		if err := target.Download(backup.Path+manifestExt, &buf); err == nil {
+			var manifest Manifest
+			if json.Unmarshal(buf.Bytes(), &manifest) == nil {
+				backup.CreatedAt = manifest.CreatedAt
+				backup.Manifest = &manifest
+			}
+		}
+
+		backups = append(backups, backup)
+		totalSize += o.Size
+	}
+
+	return backups, totalSize, nil
+}
+
+// fetchBackup downloads a backup from its target along with its manifest
+// and checks it. The returned cleanup function removes the local copy
+func (bm *BackupManager) fetchBackup(backup Backup) (*Backup, func(), error) {
+	target, err := bm.target(backup.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(bm.BackupDir, ".download-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	local := Backup{Name: backup.Name, Path: filepath.Join(tmpDir, backup.Name), CreatedAt: backup.CreatedAt}
+	fmt.Printf("Downloading %s from %s...\n", backup.Name, backup.Target)
+	if err := downloadTo(target, backup.Path, local.Path); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("error downloading backup: %v", err)
+	}
+	if backup.Manifest != nil {
+		if err := downloadTo(target, backup.Path+manifestExt, manifestPath(local.Path)); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("error downloading manifest: %v", err)
+		}
+		local.Manifest = backup.Manifest
+	}
+
+	if err := bm.VerifyBackup(local); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("downloaded backup failed verification: %v", err)
+	}
+
+	return &local, cleanup, nil
+}
+
+func downloadTo(target BackupTarget, key, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := target.Download(key, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// targetPolicy returns the retention policy of a target, the local one unless it sets its own
+func (bm *BackupManager) targetPolicy(name string) config.RetentionPolicy {
+	if targetCfg, ok := bm.targetConfig(name); ok && targetCfg.Retention != nil {
+		return *targetCfg.Retention
+	}
+	return bm.retentionPolicy()
+}
+
+// pruneTarget applies a target's retention policy to a world's backups on it
+func (bm *BackupManager) pruneTarget(target BackupTarget, worldName string, dryRun bool) ([]PruneDecision, error) {
+	backups, _, err := bm.targetBackups(target, worldName)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := planRetention(backups, bm.targetPolicy(target.Name()))
+	if dryRun {
+		return decisions, nil
+	}
+
+	for _, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		if err := target.Delete(decision.Backup.Path); err != nil {
+			return decisions, fmt.Errorf("error removing old backup %s: %v", decision.Backup.Name, err)
+		}
+		if decision.Backup.Manifest != nil {
+			target.Delete(decision.Backup.Path + manifestExt)
+		}
+	}
+
+	return decisions, nil
+}
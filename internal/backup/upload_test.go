@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPendingUploads(t *testing.T) {
+	bm := &BackupManager{BackupDir: t.TempDir()}
+	files := []string{
+		"lock",
+		"remote/world/world_2024-01-02_03-04-05.zip",
+		"remote/world/world_2024-01-02_03-04-05.snapshot",
+		"s3/other/other_2024-01-02_03-04-05.tar.zst.enc",
+		// Left behind by an interrupted snapshot export
+		"export-123/world/level.dat",
+		"export-123/world/db/CURRENT",
+		"manifest-456",
+		"remote/world/notes.txt",
+	}
+	for _, name := range files {
+		path := filepath.Join(bm.uploadsDir(), filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	markers, err := bm.pendingUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"remote/world/world_2024-01-02_03-04-05.snapshot",
+		"remote/world/world_2024-01-02_03-04-05.zip",
+		"s3/other/other_2024-01-02_03-04-05.tar.zst.enc",
+	}
+	if strings.Join(markers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got markers\n%s\nwant\n%s", strings.Join(markers, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -20,13 +20,92 @@ type WorldDefaults struct {
 	MaxPlayers   int    `yaml:"max_players"`
 }
 
+type RestartPolicy struct {
+	Enabled     bool `yaml:"enabled"`
+	Backoff     int  `yaml:"backoff"`
+	MaxBackoff  int  `yaml:"max_backoff"`
+	MaxRestarts int  `yaml:"max_restarts"`
+	Window      int  `yaml:"window"`
+}
+
+// RetentionPolicy decides which backups survive pruning, per world
+// A backup is kept if any rule selects it
+type RetentionPolicy struct {
+	KeepLast     int `yaml:"keep_last"`
+	KeepHourly   int `yaml:"keep_hourly"`
+	KeepDaily    int `yaml:"keep_daily"`
+	KeepWeekly   int `yaml:"keep_weekly"`
+	KeepMonthly  int `yaml:"keep_monthly"`
+	MaxTotalSize int `yaml:"max_total_size"`
+}
+
+// Validate checks that no setting of the policy is negative
+func (r RetentionPolicy) Validate() error {
+	if r.KeepLast < 0 || r.KeepHourly < 0 || r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0 {
+		return fmt.Errorf("keep_ settings must be non-negative")
+	}
+	if r.MaxTotalSize < 0 {
+		return fmt.Errorf("max_total_size must be non-negative")
+	}
+	return nil
+}
+
+// HasKeepRules reports whether any keep_ setting is in use
+func (r RetentionPolicy) HasKeepRules() bool {
+	return r.KeepLast > 0 || r.KeepHourly > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+}
+
+// BackupTargetConfig describes a place backups are copied to after they are made
+// Which fields are used depends on the type: local, sftp or s3
+type BackupTargetConfig struct {
+	Name       string           `yaml:"name"`
+	Type       string           `yaml:"type"`
+	Path       string           `yaml:"path"`
+	Host       string           `yaml:"host"`
+	User       string           `yaml:"user"`
+	Password   string           `yaml:"password"`
+	KeyFile    string           `yaml:"key_file"`
+	KnownHosts string           `yaml:"known_hosts"`
+	Endpoint   string           `yaml:"endpoint"`
+	Region     string           `yaml:"region"`
+	Bucket     string           `yaml:"bucket"`
+	AccessKey  string           `yaml:"access_key"`
+	SecretKey  string           `yaml:"secret_key"`
+	PathStyle  bool             `yaml:"path_style"`
+	Retention  *RetentionPolicy `yaml:"retention"`
+}
+
+// EncryptionConfig enables encryption of backup archives
+// Manifests stay unencrypted next to the archives, locally and on backup targets,
+// so they still reveal the names, sizes and checksums of a world's files
+type EncryptionConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	KeyFile    string `yaml:"key_file"`
+	Passphrase string `yaml:"passphrase"`
+}
+
 type Config struct {
 	ServerDirectory  string       `yaml:"server_directory"`
 	WorldsDirectory string       `yaml:"worlds_directory"`
 	BackupDirectory string       `yaml:"backup_directory"`
 	BackupInterval  int          `yaml:"backup_interval"`
 	BackupsToKeep   int          `yaml:"backups_to_keep"`
+	Retention       RetentionPolicy `yaml:"retention"`
+	BackupBackend   string       `yaml:"backup_backend"`
+	BackupFormat    string       `yaml:"backup_format"`
+	CompressionLevel int         `yaml:"compression_level"`
+	BackupTargets   []BackupTargetConfig `yaml:"backup_targets"`
+	Encryption      EncryptionConfig `yaml:"encryption"`
 	ServerName      string       `yaml:"server_name"`
+	VersionsURL     string       `yaml:"versions_url"`
+	CacheDirectory  string       `yaml:"cache_directory"`
+	CacheMaxSize    int          `yaml:"cache_max_size"`
+	StopTimeout     int          `yaml:"stop_timeout"`
+	KillTimeout     int          `yaml:"kill_timeout"`
+	StopCountdown   int          `yaml:"stop_countdown"`
+	LogMaxSize      int          `yaml:"log_max_size"`
+	LogMaxAge       int          `yaml:"log_max_age"`
+	Restart         RestartPolicy `yaml:"restart"`
 	WorldDefaults   WorldDefaults `yaml:"world_defaults"`
 }
 
@@ -44,12 +123,17 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	// Start from the defaults so settings missing from older config files keep working
+	config := GetDefaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("error parsing config file: %v", err)
 	}
 
-	return &config, nil
+	if err := config.ValidateConfig(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+
+	return config, nil
 }
 
 	// SaveConfig saves the configuration to the specified file
@@ -83,11 +167,97 @@ func (c *Config) ValidateConfig() error {
 	if c.BackupDirectory == "" {
 		return fmt.Errorf("backup_directory cannot be empty")
 	}
+	if c.VersionsURL == "" {
+		return fmt.Errorf("versions_url cannot be empty")
+	}
+	if c.CacheDirectory == "" {
+		return fmt.Errorf("cache_directory cannot be empty")
+	}
+	if c.CacheMaxSize < 0 {
+		return fmt.Errorf("cache_max_size must be non-negative")
+	}
+	if c.StopTimeout <= 0 {
+		return fmt.Errorf("stop_timeout must be positive")
+	}
+	if c.KillTimeout <= 0 {
+		return fmt.Errorf("kill_timeout must be positive")
+	}
+	if c.StopCountdown < 0 {
+		return fmt.Errorf("stop_countdown must be non-negative")
+	}
+	if c.LogMaxSize < 0 {
+		return fmt.Errorf("log_max_size must be non-negative")
+	}
+	if c.LogMaxAge < 0 {
+		return fmt.Errorf("log_max_age must be non-negative")
+	}
+	if c.Restart.Backoff <= 0 || c.Restart.MaxBackoff <= 0 {
+		return fmt.Errorf("restart backoff must be positive")
+	}
+	if c.Restart.MaxRestarts < 0 || c.Restart.Window < 0 {
+		return fmt.Errorf("restart max_restarts and window must be non-negative")
+	}
 	if c.BackupInterval < 0 {
 		return fmt.Errorf("backup_interval must be non-negative")
 	}
 	if c.BackupsToKeep < 0 {
 		return fmt.Errorf("backups_to_keep must be non-negative")
 	}
+	if c.BackupBackend != "archive" && c.BackupBackend != "repository" {
+		return fmt.Errorf("backup_backend must be archive or repository")
+	}
+	switch c.BackupFormat {
+	case "zip", "tar.gz":
+		if c.CompressionLevel < 0 || c.CompressionLevel > 9 {
+			return fmt.Errorf("compression_level must be between 0 and 9 for %s", c.BackupFormat)
+		}
+	case "tar.zst":
+		if c.CompressionLevel < 0 || c.CompressionLevel > 22 {
+			return fmt.Errorf("compression_level must be between 0 and 22 for tar.zst")
+		}
+	default:
+		return fmt.Errorf("backup_format must be zip, tar.gz or tar.zst")
+	}
+	if c.Encryption.Enabled && c.Encryption.KeyFile == "" && c.Encryption.Passphrase == "" {
+		return fmt.Errorf("encryption needs a key_file or a passphrase")
+	}
+	names := make(map[string]bool)
+	for _, t := range c.BackupTargets {
+		if t.Name == "" {
+			return fmt.Errorf("backup_targets entries need a name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("backup target %s is defined twice", t.Name)
+		}
+		names[t.Name] = true
+
+		switch t.Type {
+		case "local":
+			if t.Path == "" {
+				return fmt.Errorf("backup target %s needs a path", t.Name)
+			}
+		case "sftp":
+			if t.Host == "" || t.User == "" {
+				return fmt.Errorf("backup target %s needs a host and user", t.Name)
+			}
+			if t.Password == "" && t.KeyFile == "" {
+				return fmt.Errorf("backup target %s needs a password or key_file", t.Name)
+			}
+		case "s3":
+			if t.Endpoint == "" || t.Bucket == "" || t.AccessKey == "" || t.SecretKey == "" {
+				return fmt.Errorf("backup target %s needs an endpoint, bucket, access_key and secret_key", t.Name)
+			}
+		default:
+			return fmt.Errorf("backup target %s has unknown type %q, use local, sftp or s3", t.Name, t.Type)
+		}
+		if t.Retention != nil {
+			if err := t.Retention.Validate(); err != nil {
+				return fmt.Errorf("backup target %s retention %v", t.Name, err)
+			}
+		}
+	}
+	if err := c.Retention.Validate(); err != nil {
+		return fmt.Errorf("retention %v", err)
+	}
 	return nil
 }
\ No newline at end of file
@@ -6,8 +6,25 @@ func GetDefaultConfig() *Config {
 		ServerDirectory:  "./server",
 		BackupDirectory: "./backups",
 		ServerName: "Bedrock Server",
+		VersionsURL: "https://net-secondary.web.minecraft-services.net/api/v1.0/download/links",
+		CacheDirectory: "./cache",
+		CacheMaxSize: 1024, // 1 GB
+		StopTimeout: 60,
+		KillTimeout: 10,
+		StopCountdown: 0,
+		LogMaxSize: 10,
+		LogMaxAge: 30,
+		Restart: RestartPolicy{
+			Enabled:     true,
+			Backoff:     5,
+			MaxBackoff:  300,
+			MaxRestarts: 5,
+			Window:      600,
+		},
 		BackupInterval:  1440, // 24 hours in minutes
 		BackupsToKeep:   7,
+		BackupBackend:   "archive",
+		BackupFormat:    "zip",
 		WorldsDirectory: "./worlds",
 		WorldDefaults: WorldDefaults{
 			LevelName:    "default_world",
@@ -39,6 +56,47 @@ server_directory: ./server
 # Directory where world configurations are stored
 worlds_directory: ./worlds
 
+# Where to look up the latest server versions. Accepts the official download
+# links JSON or any HTML page linking to bedrock-server-{version}.zip files
+versions_url: https://net-secondary.web.minecraft-services.net/api/v1.0/download/links
+
+# Directory where downloaded server archives are cached
+cache_directory: ./cache
+
+# Maximum size of the download cache in MB (set to 0 for no limit)
+cache_max_size: 1024
+
+# SERVER SETTINGS
+# Seconds to wait for the server to save and quit after the "stop" command
+stop_timeout: 60
+
+# Seconds to wait after each termination signal before escalating
+kill_timeout: 10
+
+# Seconds of in-game countdown announced before stopping (0 to stop right away)
+stop_countdown: 0
+
+# LOG SETTINGS
+# Server output is kept in the logs directory inside the server directory
+# Rotate the server log once it grows beyond this many MB (set to 0 to only rotate daily)
+log_max_size: 10
+
+# Delete rotated logs older than this many days (set to 0 to keep all logs)
+log_max_age: 30
+
+# CRASH RECOVERY
+# Restart the server when it exits without being asked to
+restart:
+  enabled: true
+  # Seconds to wait before the first restart, doubled after each crash
+  backoff: 5
+  # Longest wait between restarts in seconds
+  max_backoff: 300
+  # Give up after this many crashes within the window (set to 0 for no limit)
+  max_restarts: 5
+  # Window in seconds
+  window: 600
+
 # BACKUP SETTINGS
 # Directory where backups will be stored
 backup_directory: ./backups
@@ -47,8 +105,77 @@ backup_directory: ./backups
 backup_interval: 1440
 
 # Number of backups to keep (set to 0 to keep all backups)
+# Only used when no keep_ setting in retention is set
 backups_to_keep: 7
 
+# How backups are stored
+# archive: one archive file per backup, see backup_format
+# repository: files are split into chunks stored once in backup_directory/.repository
+#             and each backup is a small snapshot listing its chunks. Unchanged
+#             world files cost no extra space, which suits frequent backups of big worlds
+backup_backend: archive
+
+# Archive format of the archive backend
+# zip: compresses on a single core, readable everywhere
+# tar.gz: gzip compressed tar, compressed on all cores
+# tar.zst: zstandard compressed tar, compressed on all cores. Fastest and smallest
+# Existing backups stay readable after changing this, the format is detected on restore
+backup_format: zip
+
+# Compression level, 0 uses the format's default
+# 1 (fastest) to 9 (smallest) for zip and tar.gz, 1 to 22 for tar.zst
+compression_level: 0
+
+# Encrypt backup archives with AES-256-GCM. Use a key_file holding 32 random bytes
+# (for example from "openssl rand -hex 32") or a passphrase. Keep a copy of the key
+# somewhere other than this server, backups can't be restored without it.
+# Repository snapshots stay unencrypted on this disk and are encrypted when uploaded
+encryption:
+  enabled: false
+  key_file: ""
+  passphrase: ""
+
+# Copy each backup to other places once it is made. Uploads run in the background
+# and are retried by "bsm backup upload" if they fail. Each target applies the
+# retention rules below unless it has its own retention block
+# backup_targets:
+#   - name: nas
+#     type: local
+#     path: /mnt/nas/bedrock-backups
+#   - name: offsite
+#     type: sftp
+#     host: backup.example.com:22
+#     user: bsm
+#     key_file: ~/.ssh/id_ed25519
+#     known_hosts: ~/.ssh/known_hosts
+#     path: backups/bedrock
+#   - name: s3
+#     type: s3
+#     endpoint: https://s3.eu-central-1.amazonaws.com
+#     region: eu-central-1
+#     bucket: my-bedrock-backups
+#     access_key: AKIA...
+#     secret_key: ...
+#     path: bedrock          # key prefix
+#     path_style: false      # true for MinIO and most self-hosted stores
+#     retention:
+#       keep_daily: 7
+#       keep_monthly: 12
+
+# Retention rules applied per world after each backup and by "bsm backup prune"
+# A backup is kept if any rule selects it. The hourly, daily, weekly and monthly
+# rules keep the newest backup of each of the last N hours, days, weeks and months
+# that have one, so a burst of manual backups can't push out older copies
+retention:
+  keep_last: 0
+  keep_hourly: 0
+  keep_daily: 0
+  keep_weekly: 0
+  keep_monthly: 0
+  # Delete the oldest backups until the world's backups fit in this many MB
+  # The newest backup is never deleted (set to 0 for no limit)
+  max_total_size: 0
+
 
 # DEFAULT WORLD SETTINGS
 
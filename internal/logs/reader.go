@@ -0,0 +1,153 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filter selects which log lines are shown
+type Filter struct {
+	Since   time.Time      // Only lines written at or after this time, zero for all
+	Pattern *regexp.Regexp // Only lines matching this pattern, nil for all
+}
+
+// Match reports whether a log line passes the filter
+func (f Filter) Match(line string) bool {
+	if !f.Since.IsZero() {
+		stamp, _, _ := strings.Cut(line, " ")
+		if t, err := time.Parse(time.RFC3339, stamp); err == nil && t.Before(f.Since) {
+			return false
+		}
+	}
+	return f.Pattern == nil || f.Pattern.MatchString(line)
+}
+
+// Read writes the matching lines of all segments in dir to out, oldest first
+func Read(dir string, filter Filter, out io.Writer) error {
+	segments, err := segmentFiles(dir)
+	if err != nil {
+		return fmt.Errorf("error reading log directory: %v", err)
+	}
+
+	for _, path := range segments {
+		// Skip segments that ended before the requested time
+		if info, err := os.Stat(path); err == nil && !filter.Since.IsZero() && info.ModTime().Before(filter.Since) {
+			continue
+		}
+		if err := readSegment(path, filter, out); err != nil {
+			return err
+		}
+	}
+
+	current := filepath.Join(dir, currentFile)
+	if _, err := os.Stat(current); os.IsNotExist(err) {
+		return nil
+	}
+	return readSegment(current, filter, out)
+}
+
+// Follow writes matching lines appended to the current segment until stop is closed
+// It starts at the end of the file and picks up the new file after a rotation
+func Follow(dir string, filter Filter, out io.Writer, stop <-chan struct{}) error {
+	path := filepath.Join(dir, currentFile)
+
+	var file *os.File
+	var reader *bufio.Reader
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// Start from the end, Read already printed what came before
+	if f, err := os.Open(path); err == nil {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+		file, reader = f, bufio.NewReader(f)
+	}
+
+	// drain prints the complete lines appended since the last call
+	var partial string
+	drain := func() {
+		for {
+			chunk, err := reader.ReadString('\n')
+			partial += chunk
+			if err != nil {
+				return
+			}
+			if line := strings.TrimRight(partial, "\n"); filter.Match(line) {
+				fmt.Fprintln(out, line)
+			}
+			partial = ""
+		}
+	}
+
+	for {
+		if file != nil {
+			drain()
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		// Reopen when the file was rotated away or created
+		current, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if file != nil {
+			if opened, err := file.Stat(); err == nil && os.SameFile(opened, current) {
+				continue
+			}
+			// Finish the rotated segment before moving on
+			drain()
+			file.Close()
+			file = nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file, reader, partial = f, bufio.NewReader(f), ""
+	}
+}
+
+// readSegment writes the matching lines of a plain or gzipped segment to out
+func readSegment(path string, filter Filter, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error decompressing %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); filter.Match(line) {
+			fmt.Fprintln(out, line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,228 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// currentFile is the segment being written to
+	currentFile = "server.log"
+
+	// rotateInterval is the longest a segment stays open, so quiet servers still rotate
+	rotateInterval = 24 * time.Hour
+
+	// segmentLayout names rotated segments after the time they were rotated
+	segmentLayout = "20060102-150405.000000000"
+)
+
+// Writer appends timestamped lines to server.log and rotates it by size and age
+// Rotated segments are gzipped and removed once they are older than MaxAge
+type Writer struct {
+	Dir     string
+	MaxSize int64
+	MaxAge  time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func NewWriter(dir string, maxSize int64, maxAge time.Duration) (*Writer, error) {
+	w := &Writer{
+		Dir:     dir,
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteLine appends a line prefixed with the current time
+func (w *Writer) WriteLine(line string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("log writer is closed")
+	}
+
+	if (w.MaxSize > 0 && w.size >= w.MaxSize) || time.Since(w.openedAt) >= rotateInterval {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fmt.Fprintf(w.file, "%s %s\n", time.Now().Format(time.RFC3339), line)
+	w.size += int64(n)
+	return err
+}
+
+// Close closes the current segment
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %v", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(w.Dir, currentFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+// rotate moves the current segment aside, compresses it and opens a new one
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	rotated := filepath.Join(w.Dir, fmt.Sprintf("server-%s.log", time.Now().Format(segmentLayout)))
+	if err := os.Rename(filepath.Join(w.Dir, currentFile), rotated); err != nil {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	// Compressing and pruning doesn't need to hold up the server output
+	go func() {
+		if err := compressFile(rotated); err != nil {
+			fmt.Printf("Warning: error compressing %s: %v\n", rotated, err)
+		}
+		if err := w.prune(); err != nil {
+			fmt.Printf("Warning: error removing old logs: %v\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// prune removes rotated segments older than MaxAge
+func (w *Writer) prune() error {
+	if w.MaxAge <= 0 {
+		return nil // Keep all logs
+	}
+
+	segments, err := segmentFiles(w.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range segments {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > w.MaxAge {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compressFile gzips path into path.gz and removes the original
+// The archive is written under a .tmp name segmentFiles ignores and renamed
+// once complete, so readers never see a partial .gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// segmentFiles returns the rotated segments in dir, oldest first
+// The names sort chronologically. A segment that has both a .log and a .log.gz
+// is in the middle of being compressed and only its .log.gz is returned
+func segmentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name()] = !entry.IsDir()
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "server-") {
+			continue
+		}
+		if strings.HasSuffix(name, ".log.gz") || (strings.HasSuffix(name, ".log") && !names[name+".gz"]) {
+			segments = append(segments, filepath.Join(dir, name))
+		}
+	}
+
+	return segments, nil
+}
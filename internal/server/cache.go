@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bsm/internal/config"
+	"bsm/utils"
+)
+
+// ArchiveCache keeps downloaded server archives keyed by version and checksum
+// Files are named bedrock-server-{version}-{sha256}.zip
+type ArchiveCache struct {
+	Dir     string
+	MaxSize int64
+}
+
+func NewArchiveCache(cfg *config.Config) *ArchiveCache {
+	return &ArchiveCache{
+		Dir:     cfg.CacheDirectory,
+		MaxSize: int64(cfg.CacheMaxSize) * 1024 * 1024,
+	}
+}
+
+// Lookup returns the cached archive for a version and its checksum
+// If sha256 is not empty only an archive with that checksum matches
+// Archives whose contents no longer match their checksum are removed
+func (c *ArchiveCache) Lookup(version, sha256 string) (string, string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(c.Dir, fmt.Sprintf("bedrock-server-%s-*.zip", version)))
+
+	// Prefer the most recently used archive
+	sort.Slice(matches, func(i, j int) bool {
+		return modTime(matches[i]).After(modTime(matches[j]))
+	})
+
+	for _, path := range matches {
+		name := strings.TrimSuffix(filepath.Base(path), ".zip")
+		expected := name[strings.LastIndex(name, "-")+1:]
+		if sha256 != "" && !strings.EqualFold(expected, sha256) {
+			continue
+		}
+
+		checksum, err := utils.FileSHA256(path)
+		if err != nil || checksum != expected {
+			fmt.Printf("Warning: removing corrupt cached archive %s\n", filepath.Base(path))
+			os.Remove(path)
+			continue
+		}
+
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return path, checksum, true
+	}
+
+	return "", "", false
+}
+
+// Store adds an archive to the cache and returns its cached path and checksum
+// Files already inside the cache directory are moved, anything else is copied
+func (c *ArchiveCache) Store(version, srcPath string) (string, string, error) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return "", "", fmt.Errorf("error creating cache directory: %v", err)
+	}
+
+	checksum, err := utils.FileSHA256(srcPath)
+	if err != nil {
+		return "", "", fmt.Errorf("error hashing archive: %v", err)
+	}
+
+	dst := filepath.Join(c.Dir, fmt.Sprintf("bedrock-server-%s-%s.zip", version, checksum))
+	if filepath.Clean(filepath.Dir(srcPath)) == filepath.Clean(c.Dir) {
+		err = os.Rename(srcPath, dst)
+	} else {
+		err = utils.CopyFile(srcPath, dst)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("error adding archive to cache: %v", err)
+	}
+
+	if err := c.evict(dst); err != nil {
+		fmt.Printf("Warning: error trimming download cache: %v\n", err)
+	}
+
+	return dst, checksum, nil
+}
+
+// evict removes the least recently used archives until the cache fits in MaxSize
+// The archive at keep is never removed
+func (c *ArchiveCache) evict(keep string) error {
+	if c.MaxSize <= 0 {
+		return nil // Unbounded cache
+	}
+
+	matches, err := filepath.Glob(filepath.Join(c.Dir, "bedrock-server-*.zip"))
+	if err != nil {
+		return err
+	}
+
+	type cachedFile struct {
+		path string
+		info os.FileInfo
+	}
+
+	var files []cachedFile
+	var total int64
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, cachedFile{path, info})
+		total += info.Size()
+	}
+
+	// Remove the least recently used archives first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	for _, file := range files {
+		if total <= c.MaxSize {
+			break
+		}
+		if file.path == keep {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			return err
+		}
+		total -= file.info.Size()
+	}
+
+	return nil
+}
+
+// ImportArchive adds a local server archive to the cache so it can be installed offline
+// If version is empty it is taken from the bedrock-server-{version}.zip file name
+// If sha256 is not empty the archive must have that checksum
+func ImportArchive(cfg *config.Config, path, version, sha256 string) (string, error) {
+	if version == "" {
+		match := serverVersionPattern.FindStringSubmatch(filepath.Base(path))
+		if match == nil {
+			return "", fmt.Errorf("can't tell the version from %s, pass it explicitly", filepath.Base(path))
+		}
+		version = match[1]
+	}
+
+	if _, _, err := importArchive(NewArchiveCache(cfg), path, version, sha256); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+// importArchive checks a local archive against sha256 and stores it in the cache
+func importArchive(cache *ArchiveCache, path, version, sha256 string) (string, string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", "", fmt.Errorf("archive %s not found", path)
+	}
+
+	if sha256 != "" {
+		checksum, err := utils.FileSHA256(path)
+		if err != nil {
+			return "", "", fmt.Errorf("error hashing archive: %v", err)
+		}
+		if !strings.EqualFold(checksum, sha256) {
+			return "", "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(path), sha256, checksum)
+		}
+	}
+
+	return cache.Store(version, path)
+}
+
+// obtainArchive returns a cached archive for the build, downloading it first if needed
+func obtainArchive(build *AvailableVersion, cfg *config.Config) (string, string, error) {
+	cache := NewArchiveCache(cfg)
+	if path, checksum, ok := cache.Lookup(build.Version, build.SHA256); ok {
+		fmt.Printf("Using cached server archive for version %s\n", build.Version)
+		return path, checksum, nil
+	}
+
+	// Local archives are never downloaded, only imported again if they left the cache
+	if path, ok := strings.CutPrefix(build.DownloadURL, "file://"); ok {
+		return importArchive(cache, path, build.Version, build.SHA256)
+	}
+
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return "", "", fmt.Errorf("error creating cache directory: %v", err)
+	}
+
+	// Partial downloads stay in the cache directory so the next attempt can resume them
+	downloadPath := filepath.Join(cache.Dir, fmt.Sprintf("bedrock-server-%s.download", build.Version))
+	opts := utils.DefaultDownloadOptions
+	opts.ExpectedSHA256 = build.SHA256
+
+	fmt.Println("Downloading server...")
+	if err := utils.DownloadFileWithOptions(build.DownloadURL, downloadPath, opts); err != nil {
+		return "", "", fmt.Errorf("error downloading server: %v", err)
+	}
+
+	return cache.Store(build.Version, downloadPath)
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Request is a command sent to the supervisor over its control socket
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response is the supervisor's reply to a Request
+type Response struct {
+	OK     bool          `json:"ok"`
+	Error  string        `json:"error,omitempty"`
+	Status *ServerStatus `json:"status,omitempty"`
+	Lines  []string      `json:"lines,omitempty"`
+}
+
+// socketPath returns the path of the supervisor's control socket
+func socketPath(serverDir string) string {
+	return filepath.Join(metadataDir(serverDir), "bsm.sock")
+}
+
+// dial connects to the supervisor's control socket
+func dial(path string) (net.Conn, error) {
+	return net.DialTimeout("unix", path, 2*time.Second)
+}
+
+// send writes a request to an open control connection and reads the reply
+// The connection stays open so streaming commands can keep using it
+func send(conn net.Conn, reader *bufio.Reader, req Request) (*Response, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("error sending command to supervisor: %v", err)
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("error reading supervisor response: %v", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("error parsing supervisor response: %v", err)
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// call sends a single request to the supervisor and returns its reply
+func call(path string, req Request) (*Response, error) {
+	conn, err := dial(path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return send(conn, bufio.NewReader(conn), req)
+}
+
+// writeResponse sends a reply over a control connection
+func writeResponse(conn net.Conn, resp Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// crashLogLines is how many lines of output are kept with each crash
+	crashLogLines = 50
+
+	// crashesToKeep is how many crash records are kept in the crash history
+	crashesToKeep = 20
+)
+
+// CrashRecord describes a single unexpected server exit
+type CrashRecord struct {
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	LastLogs []string  `json:"last_logs"`
+}
+
+// CrashHistory is the total crash count and the most recent crashes
+type CrashHistory struct {
+	Count   int           `json:"count"`
+	Crashes []CrashRecord `json:"crashes"`
+}
+
+func crashFile(serverDir string) string {
+	return filepath.Join(metadataDir(serverDir), "crashes.json")
+}
+
+// ReadCrashHistory reads the recorded crashes from the server directory
+func ReadCrashHistory(serverDir string) (*CrashHistory, error) {
+	data, err := os.ReadFile(crashFile(serverDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &CrashHistory{}, nil
+		}
+		return nil, fmt.Errorf("error reading crash history: %v", err)
+	}
+
+	var history CrashHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("error parsing crash history: %v", err)
+	}
+
+	return &history, nil
+}
+
+// recordCrash adds a crash to the history and returns the updated history
+func recordCrash(serverDir string, record CrashRecord) (*CrashHistory, error) {
+	history, err := ReadCrashHistory(serverDir)
+	if err != nil {
+		return nil, err
+	}
+
+	history.Count++
+	history.Crashes = append(history.Crashes, record)
+	if len(history.Crashes) > crashesToKeep {
+		history.Crashes = history.Crashes[len(history.Crashes)-crashesToKeep:]
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling crash history: %v", err)
+	}
+	if err := os.WriteFile(crashFile(serverDir), data, 0644); err != nil {
+		return nil, fmt.Errorf("error writing crash history: %v", err)
+	}
+
+	return history, nil
+}
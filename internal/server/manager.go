@@ -1,160 +1,243 @@
 package server
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 )
 
+// ServerManager controls the server through the supervisor daemon's control socket
 type ServerManager struct {
-	serverDir string
-	pidFile   string
+	serverDir  string
+	socketPath string
 }
 
 func NewServerManager(serverDir string) *ServerManager {
 	return &ServerManager{
-		serverDir: serverDir,
-		pidFile:   filepath.Join(serverDir, "server.pid"),
+		serverDir:  serverDir,
+		socketPath: socketPath(serverDir),
 	}
 }
 
-// Start launches the Bedrock server in the background
+// Start launches the Bedrock server, starting the supervisor daemon if needed
 func (sm *ServerManager) Start() error {
-	// Check if server is already running
-	if pid, _ := sm.getServerPID(); pid > 0 {
-		return fmt.Errorf("server is already running with PID %d", pid)
+	if err := sm.ensureDaemon(); err != nil {
+		return err
 	}
 
-	// Check if bedrock_server exists
-	serverPath := filepath.Join(sm.serverDir, "bedrock_server")
-	if _, err := os.Stat(serverPath); err != nil {
-		return fmt.Errorf("bedrock_server not found in %s", sm.serverDir)
-	}
-
-	// Make sure the server file is executable
-	if err := os.Chmod(serverPath, 0755); err != nil {
-		return fmt.Errorf("failed to make server executable: %v", err)
-	}
+	_, err := call(sm.socketPath, Request{Command: "start"})
+	return err
+}
 
-	// Start the server process
-	cmd := exec.Command(serverPath)
-	cmd.Dir = sm.serverDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// Stop gracefully stops the Bedrock server using the configured countdown
+func (sm *ServerManager) Stop() error {
+	return sm.stop(Request{Command: "stop"})
+}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start server: %v", err)
-	}
+// StopWithCountdown announces the shutdown in-game for the given time before stopping
+func (sm *ServerManager) StopWithCountdown(countdown time.Duration) error {
+	seconds := strconv.Itoa(int(countdown.Seconds()))
+	return sm.stop(Request{Command: "stop", Args: []string{seconds}})
+}
 
-	// Write PID file
-	if err := os.WriteFile(sm.pidFile, []byte(fmt.Sprintf("%d", cmd.Process.Pid)), 0644); err != nil {
-		return fmt.Errorf("failed to write PID file: %v", err)
+func (sm *ServerManager) stop(req Request) error {
+	if !sm.daemonRunning() {
+		return fmt.Errorf("server is not running")
 	}
 
-	// Start a goroutine to clean up PID file when process exits
-	go func() {
-		cmd.Wait()
-		os.Remove(sm.pidFile)
-	}()
-
-	return nil
+	_, err := call(sm.socketPath, req)
+	return err
 }
 
-// Stop gracefully stops the Bedrock server
-func (sm *ServerManager) Stop() error {
-	pid, err := sm.getServerPID()
+// Status returns the current status of the server
+func (sm *ServerManager) Status() (string, error) {
+	status, err := sm.StatusInfo()
 	if err != nil {
-		return fmt.Errorf("failed to get server PID: %v", err)
+		return "", fmt.Errorf("failed to get server status: %v", err)
 	}
 
-	if pid <= 0 {
-		return fmt.Errorf("server is not running")
+	switch status.State {
+	case StateRunning:
+		return fmt.Sprintf("%s (PID: %d, players online: %d)", status.State, status.PID, status.PlayersOnline), nil
+	case StateStarting, StateStopping:
+		return fmt.Sprintf("%s (PID: %d)", status.State, status.PID), nil
+	case StateCrashed:
+		if status.RestartPending {
+			return fmt.Sprintf("crashed (%s), restart pending", status.LastExit), nil
+		}
+		return fmt.Sprintf("crashed (%s)", status.LastExit), nil
+	}
+	return string(status.State), nil
+}
+
+// StatusInfo returns the supervisor's view of the server
+// Reports a stopped server when the daemon isn't running
+func (sm *ServerManager) StatusInfo() (*ServerStatus, error) {
+	if !sm.daemonRunning() {
+		return &ServerStatus{State: StateStopped}, nil
 	}
 
-	// Try to terminate gracefully first
-	process, err := os.FindProcess(pid)
+	resp, err := call(sm.socketPath, Request{Command: "status"})
 	if err != nil {
-		return fmt.Errorf("failed to find process: %v", err)
+		return nil, err
 	}
+	return resp.Status, nil
+}
 
-	// Send SIGTERM for graceful shutdown
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		return fmt.Errorf("failed to send termination signal: %v", err)
+// IsRunning checks if the server process is currently running
+func (sm *ServerManager) IsRunning() bool {
+	status, err := sm.StatusInfo()
+	if err != nil {
+		return false
 	}
+	return status.State == StateStarting || status.State == StateRunning
+}
 
-	// Wait for up to 30 seconds for the server to shut down
-	for i := 0; i < 30; i++ {
-		if !sm.IsRunning() {
+// WaitHealthy waits for the server to report that it finished starting
+// Returns an error if the process exits or the timeout passes first
+func (sm *ServerManager) WaitHealthy(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		status, err := sm.StatusInfo()
+		if err != nil {
+			return err
+		}
+
+		switch status.State {
+		case StateRunning:
 			return nil
+		case StateStopped, StateCrashed:
+			return fmt.Errorf("server exited during startup (%s)", status.LastExit)
 		}
 		time.Sleep(time.Second)
 	}
+	return fmt.Errorf("server did not finish starting within %s", timeout)
+}
 
-	// Force kill if still running
-	if err := process.Kill(); err != nil {
-		return fmt.Errorf("failed to force kill server: %v", err)
+// Exec sends a single console command and returns the output it produced
+func (sm *ServerManager) Exec(command string) ([]string, error) {
+	if !sm.daemonRunning() {
+		return nil, fmt.Errorf("server is not running")
 	}
 
-	// Clean up PID file
-	os.Remove(sm.pidFile)
-	return nil
+	resp, err := call(sm.socketPath, Request{Command: "exec", Args: []string{command}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Lines, nil
 }
 
-// Status returns the current status of the server
-func (sm *ServerManager) Status() (string, error) {
-	pid, err := sm.getServerPID()
+// Attach connects in and out to the live server console until in is closed
+// or Ctrl-C is pressed. Detaching leaves the server running
+func (sm *ServerManager) Attach(in io.Reader, out io.Writer) error {
+	conn, err := dial(sm.socketPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to get server status: %v", err)
+		return fmt.Errorf("server is not running")
 	}
+	defer conn.Close()
 
-	if pid <= 0 {
-		return "stopped", nil
+	reader := bufio.NewReader(conn)
+	resp, err := send(conn, reader, Request{Command: "attach"})
+	if err != nil {
+		return err
 	}
-
-	if sm.IsRunning() {
-		return fmt.Sprintf("running (PID: %d)", pid), nil
+	for _, line := range resp.Lines {
+		fmt.Fprintln(out, line)
 	}
 
-	// Clean up stale PID file
-	os.Remove(sm.pidFile)
-	return "stopped", nil
+	// Console output until the supervisor goes away
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(out, reader)
+		close(closed)
+	}()
+
+	// Ctrl-C detaches too, instead of killing bsm halfway through
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// Commands until the input ends
+	input := make(chan struct{})
+	go func() {
+		io.Copy(conn, in)
+		close(input)
+	}()
+
+	select {
+	case <-input:
+	case <-closed:
+	case <-interrupt:
+		fmt.Fprintln(out)
+	}
+	return nil
 }
 
-// IsRunning checks if the server process is currently running
-func (sm *ServerManager) IsRunning() bool {
-	pid, err := sm.getServerPID()
-	if err != nil || pid <= 0 {
-		return false
+// Shutdown stops the server and the supervisor daemon
+func (sm *ServerManager) Shutdown() error {
+	if !sm.daemonRunning() {
+		return fmt.Errorf("supervisor is not running")
 	}
 
-	process, err := os.FindProcess(pid)
+	_, err := call(sm.socketPath, Request{Command: "shutdown"})
+	return err
+}
+
+// daemonRunning reports whether the supervisor is accepting connections
+func (sm *ServerManager) daemonRunning() bool {
+	conn, err := dial(sm.socketPath)
 	if err != nil {
 		return false
 	}
-
-	// Send signal 0 to check if process exists
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	conn.Close()
+	return true
 }
 
-// getServerPID reads the PID from the PID file
-func (sm *ServerManager) getServerPID() (int, error) {
-	if _, err := os.Stat(sm.pidFile); os.IsNotExist(err) {
-		return 0, nil
+// ensureDaemon starts `bsm daemon` in the background unless it is already running
+// The daemon inherits the working directory so it reads the same config file
+func (sm *ServerManager) ensureDaemon() error {
+	if sm.daemonRunning() {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate bsm executable: %v", err)
 	}
 
-	data, err := os.ReadFile(sm.pidFile)
+	if err := os.MkdirAll(metadataDir(sm.serverDir), 0755); err != nil {
+		return fmt.Errorf("error creating metadata directory: %v", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(metadataDir(sm.serverDir), "daemon.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to open daemon log: %v", err)
 	}
+	defer logFile.Close()
 
-	var pid int
-	if _, err := fmt.Sscanf(string(data), "%d", &pid); err != nil {
-		return 0, err
+	cmd := exec.Command(exe, "daemon")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Detach from the terminal so the daemon outlives this command
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start supervisor: %v", err)
 	}
+	cmd.Process.Release()
 
-	return pid, nil
-}
\ No newline at end of file
+	// Wait for the control socket to come up
+	for i := 0; i < 50; i++ {
+		if sm.daemonRunning() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("supervisor did not start, see %s", logFile.Name())
+}
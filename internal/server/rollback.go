@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"bsm/utils"
+)
+
+// buildsToKeep is the number of previous server builds retained for rollback
+const buildsToKeep = 3
+
+func versionsDir(serverDir string) string {
+	return filepath.Join(metadataDir(serverDir), "versions")
+}
+
+// ListBuilds returns the retained server builds, newest first
+func ListBuilds(serverDir string) ([]InstalledVersion, error) {
+	entries, err := os.ReadDir(versionsDir(serverDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading versions directory: %v", err)
+	}
+
+	var builds []InstalledVersion
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		buildDir := filepath.Join(versionsDir(serverDir), entry.Name())
+		build, err := ReadInstalledVersion(buildDir)
+		if err != nil || build == nil {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			build = &InstalledVersion{Version: entry.Name(), InstalledAt: info.ModTime()}
+		}
+		builds = append(builds, *build)
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].InstalledAt.After(builds[j].InstalledAt)
+	})
+
+	return builds, nil
+}
+
+// RollbackServer switches the server to a retained build
+// The currently installed build is retained so it can be switched back to
+func RollbackServer(serverDir, version string) error {
+	if _, err := CheckBuild(serverDir, version); err != nil {
+		return err
+	}
+
+	// The build being rolled back to must survive pruning the snapshot makes room for
+	if !IsVersionInstalled(serverDir, version) {
+		if _, err := snapshotBuild(serverDir, version); err != nil {
+			return err
+		}
+	}
+
+	return restoreBuild(serverDir, version)
+}
+
+// validateBuildName rejects versions that would resolve outside the versions directory
+func validateBuildName(version string) error {
+	if version == "" || version == "." || strings.Contains(version, "..") || strings.ContainsAny(version, `/\`) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
+
+// CheckBuild returns the directory of a retained build after checking it holds a server
+func CheckBuild(serverDir, version string) (string, error) {
+	if err := validateBuildName(version); err != nil {
+		return "", err
+	}
+	buildDir := filepath.Join(versionsDir(serverDir), version)
+	if _, err := os.Stat(buildDir); err != nil {
+		return "", fmt.Errorf("build %s not found", version)
+	}
+	if _, err := os.Stat(filepath.Join(buildDir, "bedrock_server")); err != nil {
+		return "", fmt.Errorf("build %s has no bedrock_server binary", version)
+	}
+	return buildDir, nil
+}
+
+// snapshotBuild copies the installed server assets into the versions directory
+// and returns the version they were saved under. The build named keep is never
+// pruned to make room for the snapshot
+func snapshotBuild(serverDir, keep string) (string, error) {
+	installed, err := ReadInstalledVersion(serverDir)
+	if err != nil {
+		return "", err
+	}
+
+	version := "previous"
+	if installed != nil {
+		version = installed.Version
+	}
+	if err := validateBuildName(version); err != nil {
+		return "", err
+	}
+
+	buildDir := filepath.Join(versionsDir(serverDir), version)
+	if err := os.RemoveAll(buildDir); err != nil {
+		return "", fmt.Errorf("error removing old snapshot: %v", err)
+	}
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating snapshot directory: %v", err)
+	}
+
+	if err := replaceAssets(serverDir, buildDir); err != nil {
+		return "", fmt.Errorf("error snapshotting server build: %v", err)
+	}
+
+	if installed != nil {
+		if err := writeInstalledVersion(buildDir, installed); err != nil {
+			return "", fmt.Errorf("error snapshotting version manifest: %v", err)
+		}
+	}
+
+	if err := pruneBuilds(serverDir, version, keep); err != nil {
+		fmt.Printf("Warning: error removing old server builds: %v\n", err)
+	}
+
+	return version, nil
+}
+
+// restoreBuild copies a retained build back over the installed server assets
+func restoreBuild(serverDir, version string) error {
+	// Check the build is usable before the installed one is removed
+	buildDir, err := CheckBuild(serverDir, version)
+	if err != nil {
+		return err
+	}
+
+	// Remove assets first so files missing from the snapshot don't linger
+	for _, name := range serverAssets {
+		if err := os.RemoveAll(filepath.Join(serverDir, name)); err != nil {
+			return fmt.Errorf("error removing %s: %v", name, err)
+		}
+	}
+
+	if err := replaceAssets(buildDir, serverDir); err != nil {
+		return fmt.Errorf("error restoring build %s: %v", version, err)
+	}
+
+	if _, err := os.Stat(versionFile(buildDir)); err == nil {
+		if err := utils.CopyFile(versionFile(buildDir), versionFile(serverDir)); err != nil {
+			return fmt.Errorf("error restoring version manifest: %v", err)
+		}
+	} else {
+		os.Remove(versionFile(serverDir))
+	}
+
+	return nil
+}
+
+// pruneBuilds removes the least recently snapshotted builds beyond buildsToKeep
+// The builds named in keep are never removed
+func pruneBuilds(serverDir string, keep ...string) error {
+	entries, err := os.ReadDir(versionsDir(serverDir))
+	if err != nil {
+		return err
+	}
+
+	var dirs []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, info)
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].ModTime().After(dirs[j].ModTime())
+	})
+
+	// Kept builds count towards the limit but are skipped when deleting
+	for i := buildsToKeep; i < len(dirs); i++ {
+		if slices.Contains(keep, dirs[i].Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(versionsDir(serverDir), dirs[i].Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// installBuild writes a fake server build to dir, installed at the given time
+func installBuild(t *testing.T, dir, version string, at time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bedrock_server"), []byte(version), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInstalledVersion(dir, &InstalledVersion{Version: version, InstalledAt: at}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollbackToOldestBuild(t *testing.T) {
+	serverDir := t.TempDir()
+	now := time.Now()
+
+	// Three retained builds, 1.0 being the oldest, and 4.0 installed
+	for i, version := range []string{"1.0", "2.0", "3.0"} {
+		buildDir := filepath.Join(versionsDir(serverDir), version)
+		at := now.Add(time.Duration(i-3) * time.Hour)
+		installBuild(t, buildDir, version, at)
+		if err := os.Chtimes(buildDir, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	installBuild(t, serverDir, "4.0", now)
+
+	if err := RollbackServer(serverDir, "1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(serverDir, "bedrock_server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1.0" {
+		t.Errorf("installed server is %q, want 1.0", data)
+	}
+	if !IsVersionInstalled(serverDir, "1.0") {
+		t.Error("version manifest wasn't restored")
+	}
+	if _, err := os.Stat(filepath.Join(versionsDir(serverDir), "4.0", "bedrock_server")); err != nil {
+		t.Error("the replaced build wasn't retained")
+	}
+}
+
+func TestRollbackRejectsBadVersions(t *testing.T) {
+	serverDir := t.TempDir()
+	installBuild(t, serverDir, "4.0", time.Now())
+
+	// A build without a server binary must not replace the installed one
+	if err := os.MkdirAll(filepath.Join(versionsDir(serverDir), "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []string{"", ".", "..", "../4.0", "a/b", `a\b`, "missing", "empty"} {
+		if err := RollbackServer(serverDir, version); err == nil {
+			t.Errorf("rolling back to %q succeeded", version)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(serverDir, "bedrock_server"))
+	if err != nil || string(data) != "4.0" {
+		t.Errorf("installed server changed to %q (%v)", data, err)
+	}
+}
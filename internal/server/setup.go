@@ -3,26 +3,18 @@ package server
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
 
 	"bsm/internal/config"
 	"bsm/utils"
 )
 
 // SetupServer downloads and sets up the Bedrock server
-func SetupServer(downloadURL string, cfg *config.Config) error {
-	// Create temporary directory for download
-	tmpDir, err := os.MkdirTemp("", "bedrock-server")
+func SetupServer(build *AvailableVersion, cfg *config.Config) error {
+	// Get server zip from the cache or download it
+	zipPath, checksum, err := obtainArchive(build, cfg)
 	if err != nil {
-		return fmt.Errorf("error creating temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Download server zip
-	zipPath := filepath.Join(tmpDir, "server.zip")
-	fmt.Println("Downloading server...")
-	if err := utils.DownloadFile(downloadURL, zipPath); err != nil {
-		return fmt.Errorf("error downloading server: %v", err)
+		return err
 	}
 
 	// Create server directory if it doesn't exist
@@ -36,6 +28,15 @@ func SetupServer(downloadURL string, cfg *config.Config) error {
 		return fmt.Errorf("error extracting server: %v", err)
 	}
 
+	if err := writeInstalledVersion(cfg.ServerDirectory, &InstalledVersion{
+		Version:     build.Version,
+		DownloadURL: build.DownloadURL,
+		SHA256:      checksum,
+		InstalledAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+
 	fmt.Printf("Server setup complete! Server installed in: %s\n", cfg.ServerDirectory)
 	return nil
-}
\ No newline at end of file
+}
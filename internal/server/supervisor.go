@@ -0,0 +1,721 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bsm/internal/config"
+	"bsm/internal/logs"
+)
+
+// State is the lifecycle state of the supervised server process
+type State string
+
+const (
+	StateStopped  State = "stopped"
+	StateStarting State = "starting"
+	StateRunning  State = "running"
+	StateStopping State = "stopping"
+	StateCrashed  State = "crashed"
+)
+
+const (
+	// scrollbackLines is how much console output is kept for attaching clients
+	scrollbackLines = 500
+
+	// execQuietPeriod and execTimeout bound how long exec collects command output
+	execQuietPeriod = 500 * time.Millisecond
+	execTimeout     = 5 * time.Second
+)
+
+// PlayerActivityPattern matches the log lines written when players join or leave
+var PlayerActivityPattern = regexp.MustCompile(`Player (connected|disconnected): `)
+
+var (
+	playerConnectedPattern    = regexp.MustCompile(`Player connected: (.+?), xuid`)
+	playerDisconnectedPattern = regexp.MustCompile(`Player disconnected: (.+?), xuid`)
+)
+
+// ServerStatus is a snapshot of the supervised server
+type ServerStatus struct {
+	State          State     `json:"state"`
+	PID            int       `json:"pid,omitempty"`
+	StartedAt      time.Time `json:"started_at,omitempty"`
+	LastExit       string    `json:"last_exit,omitempty"`
+	RestartPending bool      `json:"restart_pending,omitempty"`
+	PlayersOnline  int       `json:"players_online"`
+}
+
+// Supervisor owns the bedrock_server process and serves the control socket
+// It runs for as long as the daemon does, independent of the CLI commands talking to it
+type Supervisor struct {
+	serverDir     string
+	socketPath    string
+	stopTimeout   time.Duration
+	killTimeout   time.Duration
+	stopCountdown time.Duration
+	logMaxSize    int64
+	logMaxAge     time.Duration
+	log           *logs.Writer
+	restart       config.RestartPolicy
+
+	mu        sync.Mutex
+	stdinMu   sync.Mutex
+	state     State
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	exited    chan struct{}
+	quit      chan struct{}
+	quitSeen  bool
+	startedAt time.Time
+	lastExit  string
+
+	restartTimer  *time.Timer
+	recentCrashes []time.Time
+
+	players     map[string]struct{}
+	scrollback  []string
+	subscribers map[chan string]struct{}
+
+	listener net.Listener
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func NewSupervisor(cfg *config.Config) *Supervisor {
+	return &Supervisor{
+		serverDir:     cfg.ServerDirectory,
+		socketPath:    socketPath(cfg.ServerDirectory),
+		stopTimeout:   time.Duration(cfg.StopTimeout) * time.Second,
+		killTimeout:   time.Duration(cfg.KillTimeout) * time.Second,
+		stopCountdown: time.Duration(cfg.StopCountdown) * time.Second,
+		logMaxSize:    int64(cfg.LogMaxSize) * 1024 * 1024,
+		logMaxAge:     time.Duration(cfg.LogMaxAge) * 24 * time.Hour,
+		restart:       cfg.Restart,
+		state:         StateStopped,
+		players:       make(map[string]struct{}),
+		subscribers:   make(map[chan string]struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Run listens on the control socket until the daemon is shut down
+func (s *Supervisor) Run() error {
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0755); err != nil {
+		return fmt.Errorf("error creating metadata directory: %v", err)
+	}
+
+	// Refuse to start twice, but clean up a socket left behind by a dead daemon
+	if conn, err := dial(s.socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("supervisor is already running")
+	}
+	os.Remove(s.socketPath)
+
+	logWriter, err := logs.NewWriter(LogDirectory(s.serverDir), s.logMaxSize, s.logMaxAge)
+	if err != nil {
+		return err
+	}
+	s.log = logWriter
+	defer logWriter.Close()
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("error listening on control socket: %v", err)
+	}
+	s.listener = listener
+	defer os.Remove(s.socketPath)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			s.logf("Received %s, shutting down", sig)
+			s.shutdown()
+		case <-s.done:
+		}
+	}()
+
+	s.logf("Supervisor listening on %s", s.socketPath)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return fmt.Errorf("error accepting control connection: %v", err)
+			}
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// shutdown stops the server if needed and makes Run return
+func (s *Supervisor) shutdown() {
+	if err := s.stopServer(0); err != nil && !errors.Is(err, errNotRunning) {
+		s.logf("Error stopping server: %v", err)
+	}
+	s.doneOnce.Do(func() {
+		close(s.done)
+		s.listener.Close()
+	})
+}
+
+func (s *Supervisor) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeResponse(conn, Response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	var resp Response
+	switch req.Command {
+	case "start":
+		err = s.startServer()
+	case "stop":
+		countdown := s.stopCountdown
+		if len(req.Args) > 0 {
+			seconds, convErr := strconv.Atoi(req.Args[0])
+			if convErr != nil || seconds < 0 {
+				err = fmt.Errorf("invalid countdown: %s", req.Args[0])
+				break
+			}
+			countdown = time.Duration(seconds) * time.Second
+		}
+		err = s.stopServer(countdown)
+	case "status":
+		status := s.status()
+		resp.Status = &status
+	case "exec":
+		if len(req.Args) < 1 {
+			err = fmt.Errorf("no command given")
+			break
+		}
+		resp.Lines, err = s.execCommand(req.Args[0], execQuietPeriod, execTimeout)
+	case "attach":
+		s.attach(conn, reader)
+		return
+	case "shutdown":
+		// Reply first, the listener goes away during shutdown
+		writeResponse(conn, Response{OK: true})
+		s.shutdown()
+		return
+	default:
+		err = fmt.Errorf("unknown command: %s", req.Command)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.OK = true
+	}
+	writeResponse(conn, resp)
+}
+
+var errNotRunning = errors.New("server is not running")
+
+// startServer launches bedrock_server with its console attached to the supervisor
+func (s *Supervisor) startServer() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == StateStarting || s.state == StateRunning || s.state == StateStopping {
+		return fmt.Errorf("server is already %s with PID %d", s.state, s.cmd.Process.Pid)
+	}
+
+	// A manual start replaces any restart still waiting after a crash
+	if s.restartTimer != nil {
+		s.restartTimer.Stop()
+		s.restartTimer = nil
+	}
+
+	// Check if bedrock_server exists
+	serverPath := filepath.Join(s.serverDir, "bedrock_server")
+	if _, err := os.Stat(serverPath); err != nil {
+		return fmt.Errorf("bedrock_server not found in %s", s.serverDir)
+	}
+
+	// Make sure the server file is executable
+	if err := os.Chmod(serverPath, 0755); err != nil {
+		return fmt.Errorf("failed to make server executable: %v", err)
+	}
+
+	absPath, err := filepath.Abs(serverPath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(absPath)
+	cmd.Dir = s.serverDir
+	// Own process group so a Ctrl-C aimed at the daemon doesn't reach the server
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open server stdin: %v", err)
+	}
+
+	// stdout and stderr share one pipe so lines stay in order
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to open server output: %v", err)
+	}
+	cmd.Stdout = outW
+	cmd.Stderr = outW
+
+	if err := cmd.Start(); err != nil {
+		outR.Close()
+		outW.Close()
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	outW.Close()
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.state = StateStarting
+	s.startedAt = time.Now()
+	s.exited = make(chan struct{})
+	s.quit = make(chan struct{})
+	s.quitSeen = false
+
+	outputDone := make(chan struct{})
+	go s.readOutput(outR, outputDone)
+	go s.wait(cmd, outputDone, s.exited)
+
+	return nil
+}
+
+// readOutput follows the server console output
+func (s *Supervisor) readOutput(r io.ReadCloser, done chan struct{}) {
+	defer close(done)
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s.handleLine(scanner.Text())
+	}
+}
+
+// handleLine processes a single line of server output
+func (s *Supervisor) handleLine(line string) {
+	if err := s.log.WriteLine(line); err != nil {
+		fmt.Printf("Error writing server log: %v\n", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrollback = append(s.scrollback, line)
+	if len(s.scrollback) > scrollbackLines {
+		s.scrollback = s.scrollback[len(s.scrollback)-scrollbackLines:]
+	}
+	for ch := range s.subscribers {
+		// Slow readers miss lines rather than stall the server output
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+
+	if strings.Contains(line, "Server started.") && s.state == StateStarting {
+		s.state = StateRunning
+	}
+	if match := playerConnectedPattern.FindStringSubmatch(line); match != nil {
+		s.players[match[1]] = struct{}{}
+	}
+	if match := playerDisconnectedPattern.FindStringSubmatch(line); match != nil {
+		delete(s.players, match[1])
+	}
+	if strings.Contains(line, "Quit correctly") && !s.quitSeen {
+		s.quitSeen = true
+		close(s.quit)
+	}
+}
+
+// sendCommand writes a command to the server console
+func (s *Supervisor) sendCommand(command string) error {
+	s.mu.Lock()
+	stdin := s.stdin
+	s.mu.Unlock()
+
+	if stdin == nil {
+		return errNotRunning
+	}
+
+	// Writes can block if the server stops reading, so they don't hold mu
+	s.stdinMu.Lock()
+	defer s.stdinMu.Unlock()
+	if _, err := io.WriteString(stdin, command+"\n"); err != nil {
+		return fmt.Errorf("failed to send command: %v", err)
+	}
+	return nil
+}
+
+// subscribe returns a channel receiving every new line of server output
+// along with the current scrollback
+func (s *Supervisor) subscribe() (chan string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan string, 256)
+	s.subscribers[ch] = struct{}{}
+	return ch, append([]string(nil), s.scrollback...)
+}
+
+func (s *Supervisor) unsubscribe(ch chan string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, ch)
+}
+
+// execCommand sends a console command and collects the output it produces
+// Collection ends once the output has been quiet for the quiet period, or at the timeout
+func (s *Supervisor) execCommand(command string, quiet, timeout time.Duration) ([]string, error) {
+	ch, _ := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	if err := s.sendCommand(command); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	deadline := time.After(timeout)
+	idle := time.NewTimer(quiet)
+	defer idle.Stop()
+	for {
+		select {
+		case line := <-ch:
+			lines = append(lines, line)
+			idle.Reset(quiet)
+		case <-idle.C:
+			return lines, nil
+		case <-deadline:
+			return lines, nil
+		}
+	}
+}
+
+// attach streams the console to a control connection until the client detaches
+// Each line the client sends is passed to the server as a command
+func (s *Supervisor) attach(conn net.Conn, reader *bufio.Reader) {
+	ch, scrollback := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	if err := writeResponse(conn, Response{OK: true, Lines: scrollback}); err != nil {
+		return
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		defer close(detached)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if command := strings.TrimSpace(line); command != "" {
+				if err := s.sendCommand(command); err != nil {
+					fmt.Fprintf(conn, "bsm: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line := <-ch:
+			if _, err := fmt.Fprintln(conn, line); err != nil {
+				return
+			}
+		case <-detached:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// wait reaps the server process and records how it exited
+func (s *Supervisor) wait(cmd *exec.Cmd, outputDone, exited chan struct{}) {
+	err := cmd.Wait()
+
+	// Let the last lines of output through first. Don't wait forever,
+	// leftover child processes can keep the pipe open
+	select {
+	case <-outputDone:
+	case <-time.After(2 * time.Second):
+	}
+
+	s.mu.Lock()
+	crashed := s.state != StateStopping && !intentionalExit(err, s.quitSeen)
+	if crashed {
+		s.state = StateCrashed
+	} else {
+		s.state = StateStopped
+	}
+	s.lastExit = describeExit(err)
+	s.stdin = nil
+	s.players = make(map[string]struct{})
+	lastLines := s.scrollback
+	if len(lastLines) > crashLogLines {
+		lastLines = lastLines[len(lastLines)-crashLogLines:]
+	}
+	lastLines = append([]string(nil), lastLines...)
+	// Arm the restart along with the state change, a stop coming in between
+	// would otherwise find no restart to cancel
+	var crashMessage string
+	if crashed {
+		crashMessage = s.scheduleRestart(s.lastExit)
+	}
+	s.mu.Unlock()
+
+	s.logf("Server exited: %s", s.lastExit)
+	close(exited)
+
+	if crashed {
+		if _, err := recordCrash(s.serverDir, CrashRecord{Time: time.Now(), Reason: describeExit(err), LastLogs: lastLines}); err != nil {
+			s.logf("Error recording crash: %v", err)
+		}
+		s.logf("%s", crashMessage)
+	}
+}
+
+// scheduleRestart arms a restart after a crash unless the restart policy is
+// disabled or too many crashes happened within its window, s.mu must be held
+// Returns the message to log
+func (s *Supervisor) scheduleRestart(reason string) string {
+	if !s.restart.Enabled {
+		return fmt.Sprintf("Server crashed (%s), automatic restart is disabled", reason)
+	}
+
+	// Only crashes inside the window count towards the limit and the backoff
+	now := time.Now()
+	window := time.Duration(s.restart.Window) * time.Second
+	var recent []time.Time
+	for _, t := range s.recentCrashes {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	s.recentCrashes = append(recent, now)
+
+	if s.restart.MaxRestarts > 0 && len(s.recentCrashes) > s.restart.MaxRestarts {
+		return fmt.Sprintf("Server crashed (%s) %d times within %s, giving up on restarting", reason, len(s.recentCrashes), window)
+	}
+
+	delay := time.Duration(s.restart.Backoff) * time.Second
+	maxDelay := time.Duration(s.restart.MaxBackoff) * time.Second
+	for i := 1; i < len(s.recentCrashes) && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	s.restartTimer = time.AfterFunc(delay, s.restartAfterCrash)
+	return fmt.Sprintf("Server crashed (%s), restarting in %s", reason, delay)
+}
+
+// restartAfterCrash starts the server again unless it was started or stopped meanwhile
+func (s *Supervisor) restartAfterCrash() {
+	s.mu.Lock()
+	s.restartTimer = nil
+	pending := s.state == StateCrashed
+	s.mu.Unlock()
+
+	if !pending {
+		return
+	}
+
+	s.logf("Restarting server after crash")
+	if err := s.startServer(); err != nil {
+		s.logf("Error restarting server: %v", err)
+	}
+}
+
+// stopServer shuts the server down through its own "stop" command so the world
+// is saved, after an optional in-game countdown. Signals are only used if the
+// server doesn't quit within the configured timeouts
+func (s *Supervisor) stopServer(countdown time.Duration) error {
+	s.mu.Lock()
+	if s.state == StateCrashed {
+		// Stopping a crashed server just cancels its pending restart, if any
+		s.state = StateStopped
+		pending := s.restartTimer != nil
+		if pending {
+			s.restartTimer.Stop()
+			s.restartTimer = nil
+		}
+		s.mu.Unlock()
+		if pending {
+			s.logf("Cancelled pending restart")
+		}
+		return nil
+	}
+	if s.state != StateStarting && s.state != StateRunning {
+		s.mu.Unlock()
+		return errNotRunning
+	}
+	s.state = StateStopping
+	process := s.cmd.Process
+	exited := s.exited
+	quit := s.quit
+	s.mu.Unlock()
+
+	s.announceStop(countdown, exited)
+
+	if err := s.sendCommand("stop"); err == nil {
+		select {
+		case <-exited:
+			return nil
+		case <-quit:
+			// World is saved, give the process a moment to exit on its own
+			select {
+			case <-exited:
+				return nil
+			case <-time.After(s.killTimeout):
+			}
+		case <-time.After(s.stopTimeout):
+			s.logf("Server did not quit within %s", s.stopTimeout)
+		}
+	}
+
+	// Fall back to SIGTERM, then SIGKILL
+	s.logf("Sending SIGTERM to server")
+	if err := process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to send termination signal: %v", err)
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(s.killTimeout):
+	}
+
+	s.logf("Killing server")
+	if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to force kill server: %v", err)
+	}
+	<-exited
+	return nil
+}
+
+// announceStop broadcasts a countdown to players before the server stops
+func (s *Supervisor) announceStop(countdown time.Duration, exited chan struct{}) {
+	if countdown <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(countdown)
+	s.sendCommand(fmt.Sprintf("say Server stopping in %ds", int(countdown.Seconds())))
+
+	// Remind players at these many seconds left
+	for _, left := range []int{300, 120, 60, 30, 10, 5, 4, 3, 2, 1} {
+		remaining := time.Duration(left) * time.Second
+		if remaining >= countdown {
+			continue
+		}
+
+		select {
+		case <-exited:
+			return
+		case <-time.After(time.Until(deadline.Add(-remaining))):
+		}
+		s.sendCommand(fmt.Sprintf("say Server stopping in %ds", left))
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Until(deadline)):
+	}
+}
+
+// status returns a snapshot of the supervised server
+func (s *Supervisor) status() ServerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := ServerStatus{
+		State:          s.state,
+		LastExit:       s.lastExit,
+		RestartPending: s.restartTimer != nil,
+		PlayersOnline:  len(s.players),
+	}
+	if s.state != StateStopped && s.state != StateCrashed {
+		status.PID = s.cmd.Process.Pid
+		status.StartedAt = s.startedAt
+	}
+	return status
+}
+
+// logf reports a supervisor event on stdout and in the server log
+func (s *Supervisor) logf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Println(message)
+	if s.log != nil {
+		s.log.WriteLine("[bsm] " + message)
+	}
+}
+
+// LogDirectory returns the directory holding the server logs
+func LogDirectory(serverDir string) string {
+	return filepath.Join(serverDir, "logs")
+}
+
+// intentionalExit reports whether an exit the supervisor didn't ask for was still
+// deliberate: "stop" typed into the console, or a termination signal from an admin
+func intentionalExit(err error, quitSeen bool) bool {
+	if err == nil {
+		return quitSeen
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return ws.Signal() == syscall.SIGTERM || ws.Signal() == syscall.SIGINT
+		}
+	}
+
+	return false
+}
+
+// describeExit turns the result of cmd.Wait into a readable exit reason
+func describeExit(err error) string {
+	if err == nil {
+		return "exit code 0"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return fmt.Sprintf("killed by signal %s", ws.Signal())
+		}
+		return fmt.Sprintf("exit code %d", exitErr.ExitCode())
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bsm/internal/config"
+	"bsm/utils"
+)
+
+// serverAssets lists the files and directories shipped in the server archive
+// that get replaced on update. Worlds, server.properties, allowlist.json and
+// permissions.json are never touched.
+var serverAssets = []string{
+	"bedrock_server",
+	"bedrock_server_how_to.html",
+	"behavior_packs",
+	"resource_packs",
+	"definitions",
+	"config",
+	"release-notes.txt",
+	"profanity_filter.wlist",
+}
+
+// bootTimeout is how long a freshly updated server gets to finish starting
+const bootTimeout = 2 * time.Minute
+
+// DownloadURL returns the official download URL for the given server version
+func DownloadURL(version string) string {
+	return fmt.Sprintf("https://www.minecraft.net/bedrockdedicatedserver/bin-linux/bedrock-server-%s.zip", version)
+}
+
+// StagedUpdate is a new server build downloaded and extracted to a staging
+// directory, ready to replace the installed one
+type StagedUpdate struct {
+	build      *AvailableVersion
+	cfg        *config.Config
+	checksum   string
+	tmpDir     string
+	stagingDir string
+}
+
+// StageUpdate gets a new server build from the cache or downloads it, and
+// extracts it. The installed server is untouched, so it can keep running
+// meanwhile. Call Cleanup once the update is installed or abandoned
+func StageUpdate(build *AvailableVersion, cfg *config.Config) (*StagedUpdate, error) {
+	if _, err := os.Stat(filepath.Join(cfg.ServerDirectory, "bedrock_server")); err != nil {
+		return nil, fmt.Errorf("no server found in %s. Run 'bsm server setup' first", cfg.ServerDirectory)
+	}
+
+	// Get server zip from the cache or download it
+	zipPath, checksum, err := obtainArchive(build, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "bedrock-server")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp directory: %v", err)
+	}
+
+	// Extract into a staging directory so a bad archive leaves the server untouched
+	stagingDir := filepath.Join(tmpDir, "staging")
+	fmt.Println("Extracting server files...")
+	if err := utils.ExtractZip(zipPath, stagingDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("error extracting server: %v", err)
+	}
+
+	return &StagedUpdate{
+		build:      build,
+		cfg:        cfg,
+		checksum:   checksum,
+		tmpDir:     tmpDir,
+		stagingDir: stagingDir,
+	}, nil
+}
+
+// Cleanup removes the staged files
+func (u *StagedUpdate) Cleanup() {
+	os.RemoveAll(u.tmpDir)
+}
+
+// Install replaces the shipped assets with the staged build, the server must be stopped
+// The new build is booted to verify it and rolled back if it doesn't come up.
+// The server is left running afterwards only if wasRunning is set, on the
+// previous build if the update failed at any point
+func (u *StagedUpdate) Install(wasRunning bool) (err error) {
+	serverDir := u.cfg.ServerDirectory
+	sm := NewServerManager(serverDir)
+
+	defer func() {
+		if err == nil || !wasRunning || sm.IsRunning() {
+			return
+		}
+		fmt.Println("Starting Bedrock server...")
+		if startErr := sm.Start(); startErr != nil {
+			err = fmt.Errorf("%v (and the server failed to start: %v)", err, startErr)
+		}
+	}()
+
+	// Keep the current build so it can be restored if the new one doesn't boot
+	previous, err := snapshotBuild(serverDir, "")
+	if err != nil {
+		return err
+	}
+
+	if err := replaceAssets(u.stagingDir, serverDir); err != nil {
+		if rbErr := restoreBuild(serverDir, previous); rbErr != nil {
+			return fmt.Errorf("%v (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := writeInstalledVersion(serverDir, &InstalledVersion{
+		Version:     u.build.Version,
+		DownloadURL: u.build.DownloadURL,
+		SHA256:      u.checksum,
+		InstalledAt: time.Now(),
+	}); err != nil {
+		if rbErr := restoreBuild(serverDir, previous); rbErr != nil {
+			return fmt.Errorf("%v (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	// Boot the new build and roll back if it doesn't come up
+	fmt.Println("Starting server to verify the update...")
+	if err := verifyBoot(sm); err != nil {
+		fmt.Printf("Server failed to start: %v\n", err)
+		fmt.Printf("Rolling back to version %s...\n", previous)
+		// Also cancels the restart the supervisor schedules when the new build crashes
+		sm.Stop()
+		if rbErr := restoreBuild(serverDir, previous); rbErr != nil {
+			return fmt.Errorf("update failed and rollback failed: %v", rbErr)
+		}
+		return fmt.Errorf("update failed, rolled back to version %s", previous)
+	}
+
+	if !wasRunning {
+		fmt.Println("Stopping Bedrock server...")
+		if err := sm.Stop(); err != nil {
+			return fmt.Errorf("error stopping server after the update: %v", err)
+		}
+	}
+
+	fmt.Printf("Server update complete! Server updated in: %s\n", serverDir)
+	return nil
+}
+
+// verifyBoot starts the server and waits for it to come up healthy
+func verifyBoot(sm *ServerManager) error {
+	if err := sm.Start(); err != nil {
+		return err
+	}
+	return sm.WaitHealthy(bootTimeout)
+}
+
+// replaceAssets copies the server assets from srcDir over the ones in dstDir
+func replaceAssets(srcDir, dstDir string) error {
+	for _, name := range serverAssets {
+		src := filepath.Join(srcDir, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+
+		dst := filepath.Join(dstDir, name)
+		if err := os.RemoveAll(dst); err != nil {
+			return fmt.Errorf("error removing old %s: %v", name, err)
+		}
+		if err := utils.CopyPath(src, dst); err != nil {
+			return fmt.Errorf("error copying %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
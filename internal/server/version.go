@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InstalledVersion describes the server build installed in the server directory
+type InstalledVersion struct {
+	Version     string    `json:"version"`
+	DownloadURL string    `json:"download_url"`
+	SHA256      string    `json:"sha256"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// metadataDir returns the directory where bsm keeps its own files inside the server directory
+func metadataDir(serverDir string) string {
+	return filepath.Join(serverDir, ".bsm")
+}
+
+func versionFile(serverDir string) string {
+	return filepath.Join(metadataDir(serverDir), "version.json")
+}
+
+// ReadInstalledVersion reads the version manifest from the server directory
+// Returns nil if no version has been recorded yet
+func ReadInstalledVersion(serverDir string) (*InstalledVersion, error) {
+	data, err := os.ReadFile(versionFile(serverDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading version manifest: %v", err)
+	}
+
+	var v InstalledVersion
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error parsing version manifest: %v", err)
+	}
+
+	return &v, nil
+}
+
+// IsVersionInstalled reports whether the given version is the one currently installed
+func IsVersionInstalled(serverDir, version string) bool {
+	installed, err := ReadInstalledVersion(serverDir)
+	return err == nil && installed != nil && installed.Version == version
+}
+
+// writeInstalledVersion saves the version manifest to the server directory
+func writeInstalledVersion(serverDir string, v *InstalledVersion) error {
+	if err := os.MkdirAll(metadataDir(serverDir), 0755); err != nil {
+		return fmt.Errorf("error creating metadata directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling version manifest: %v", err)
+	}
+
+	if err := os.WriteFile(versionFile(serverDir), data, 0644); err != nil {
+		return fmt.Errorf("error writing version manifest: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"bsm/utils"
+)
+
+// AvailableVersion is a server build offered for download
+type AvailableVersion struct {
+	Version     string
+	DownloadURL string
+	SHA256      string // Expected archive checksum, empty if unknown
+}
+
+// AvailableVersions holds the current release and preview builds
+type AvailableVersions struct {
+	Release *AvailableVersion
+	Preview *AvailableVersion
+}
+
+// downloadLinks mirrors the official download links JSON
+type downloadLinks struct {
+	Result struct {
+		Links []struct {
+			DownloadType string `json:"downloadType"`
+			DownloadURL  string `json:"downloadUrl"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+var (
+	linuxServerURLPattern = regexp.MustCompile(`https?://[^"'\s<>]+/bin-linux(-preview)?/bedrock-server-[0-9.]+\.zip`)
+	serverVersionPattern  = regexp.MustCompile(`bedrock-server-([0-9.]+)\.zip`)
+)
+
+// FetchAvailableVersions looks up the current release and preview server builds
+// The endpoint may serve the official download links JSON or an HTML download page
+func FetchAvailableVersions(url string) (*AvailableVersions, error) {
+	data, err := utils.FetchURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching versions: %v", err)
+	}
+
+	available := &AvailableVersions{}
+
+	var links downloadLinks
+	if err := json.Unmarshal(data, &links); err == nil && len(links.Result.Links) > 0 {
+		for _, link := range links.Result.Links {
+			switch link.DownloadType {
+			case "serverBedrockLinux":
+				available.Release = versionFromURL(link.DownloadURL)
+			case "serverBedrockPreviewLinux":
+				available.Preview = versionFromURL(link.DownloadURL)
+			}
+		}
+	} else {
+		for _, match := range linuxServerURLPattern.FindAllStringSubmatch(string(data), -1) {
+			if match[1] == "" && available.Release == nil {
+				available.Release = versionFromURL(match[0])
+			} else if match[1] != "" && available.Preview == nil {
+				available.Preview = versionFromURL(match[0])
+			}
+		}
+	}
+
+	if available.Release == nil && available.Preview == nil {
+		return nil, fmt.Errorf("no Linux server downloads found at %s", url)
+	}
+
+	return available, nil
+}
+
+// versionFromURL extracts the version from a bedrock-server-{version}.zip URL
+func versionFromURL(url string) *AvailableVersion {
+	match := serverVersionPattern.FindStringSubmatch(url)
+	if match == nil {
+		return nil
+	}
+	return &AvailableVersion{Version: match[1], DownloadURL: url}
+}
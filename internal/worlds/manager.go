@@ -156,6 +156,55 @@ func (wm *WorldManager) CreateWorld() error {
 	return nil
 }
 
+// ImportWorld creates the entry of a world copied from another one, such as a
+// restored backup, from the server.properties and allowlist.json in configDir
+// level-name is set to the new name. Missing files fall back to the server's
+// properties and an empty allowlist
+func (wm *WorldManager) ImportWorld(name, configDir string) error {
+	worldDir := filepath.Join(wm.WorldsDir, name)
+	if err := os.MkdirAll(worldDir, 0755); err != nil {
+		return fmt.Errorf("error creating world directory: %v", err)
+	}
+
+	props, err := os.ReadFile(filepath.Join(configDir, "server.properties"))
+	if err != nil {
+		props, err = os.ReadFile(filepath.Join(wm.ServerDir, "server.properties"))
+		if err != nil {
+			return fmt.Errorf("error reading template properties: %v", err)
+		}
+	}
+	props = setProperty(props, "level-name", name)
+	if err := os.WriteFile(filepath.Join(worldDir, "server.properties"), props, 0644); err != nil {
+		return fmt.Errorf("error creating properties file: %v", err)
+	}
+
+	allowlistPath := filepath.Join(worldDir, "allowlist.json")
+	if err := utils.CopyFile(filepath.Join(configDir, "allowlist.json"), allowlistPath); err != nil {
+		if err := os.WriteFile(allowlistPath, []byte("[]"), 0644); err != nil {
+			return fmt.Errorf("error creating allowlist.json: %v", err)
+		}
+	}
+	return nil
+}
+
+// setProperty sets key in the contents of a properties file, adding it if missing
+func setProperty(data []byte, key, value string) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && !strings.HasPrefix(line, "#") && strings.TrimSpace(parts[0]) == key {
+			lines[i] = key + "=" + value
+			return []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	lines = append(lines, key+"="+value, "")
+	return []byte(strings.Join(lines, "\n"))
+}
+
 func (wm *WorldManager) createPropertiesFile(path string, props map[string]string) error {
 	// First read the template properties file from the server directory
 	templatePath := filepath.Join(wm.ServerDir, "server.properties")
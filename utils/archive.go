@@ -0,0 +1,455 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// Archive formats, also used as file extensions
+const (
+	FormatZip    = "zip"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// ArchiveFormats lists every format WriteArchive can write
+var ArchiveFormats = []string{FormatZip, FormatTarGz, FormatTarZst}
+
+// Compressed data is split into blocks of this size to compress them on all cores
+const compressBlockSize = 4 << 20
+
+// WriteArchive writes an archive of src to w in the given format
+// files lists what to include for live copies, only the first Size bytes of
+// each are written, nil includes the whole directory. A level of 0 uses the
+// format's default
+func WriteArchive(w io.Writer, src string, files []TruncatedFile, format string, level int) error {
+	if format == FormatZip {
+		archive := zip.NewWriter(w)
+		archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			if level == 0 {
+				return flate.NewWriter(out, flate.DefaultCompression)
+			}
+			return flate.NewWriter(out, level)
+		})
+		if files == nil {
+			return writeZipDirectory(archive, src)
+		}
+		return writeZipTruncatedFiles(archive, src, files)
+	}
+
+	compressor, err := newCompressor(w, format, level)
+	if err != nil {
+		return err
+	}
+	if err := writeTar(compressor, src, files); err != nil {
+		compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
+
+// newCompressor returns a writer compressing into w on all cores
+func newCompressor(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	switch format {
+	case FormatTarGz:
+		if level == 0 {
+			level = pgzip.DefaultCompression
+		}
+		gz, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := gz.SetConcurrency(compressBlockSize, runtime.NumCPU()); err != nil {
+			return nil, err
+		}
+		return gz, nil
+
+	case FormatTarZst:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.NumCPU())}
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		encoder, err := zstd.NewWriter(nil, options...)
+		if err != nil {
+			return nil, err
+		}
+		// A zstd stream only compresses one block at a time, so compress independent
+		// frames in parallel instead. Readers decode concatenated frames as one stream
+		return newParallelWriter(w, runtime.NumCPU(), func(block []byte) []byte {
+			return encoder.EncodeAll(block, nil)
+		}, encoder.Close), nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q", format)
+}
+
+// parallelWriter compresses blocks of its input on several goroutines and
+// writes the results to w in order
+type parallelWriter struct {
+	w        io.Writer
+	compress func(block []byte) []byte
+	release  func() error
+	buf      []byte
+	queue    chan chan []byte
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelWriter(w io.Writer, workers int, compress func([]byte) []byte, release func() error) *parallelWriter {
+	p := &parallelWriter{
+		w:        w,
+		compress: compress,
+		release:  release,
+		buf:      make([]byte, 0, compressBlockSize),
+		queue:    make(chan chan []byte, workers),
+		done:     make(chan struct{}),
+	}
+	go p.writeLoop()
+	return p
+}
+
+func (p *parallelWriter) writeLoop() {
+	defer close(p.done)
+	for result := range p.queue {
+		out := <-result
+		if p.error() != nil {
+			continue
+		}
+		if _, err := p.w.Write(out); err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *parallelWriter) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *parallelWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if err := p.error(); err != nil {
+			return written, err
+		}
+		n := copy(p.buf[len(p.buf):cap(p.buf)], data)
+		p.buf = p.buf[:len(p.buf)+n]
+		data = data[n:]
+		written += n
+		if len(p.buf) == cap(p.buf) {
+			p.flush()
+		}
+	}
+	return written, nil
+}
+
+// flush hands the buffered block to a goroutine, blocking while all workers are busy
+func (p *parallelWriter) flush() {
+	block := p.buf
+	result := make(chan []byte, 1)
+	p.queue <- result
+	go func() {
+		result <- p.compress(block)
+	}()
+	p.buf = make([]byte, 0, compressBlockSize)
+}
+
+func (p *parallelWriter) Close() error {
+	p.flush()
+	close(p.queue)
+	<-p.done
+	if err := p.release(); err != nil && p.err == nil {
+		return err
+	}
+	return p.err
+}
+
+// writeTar writes a tar archive of src to w, see WriteArchive
+func writeTar(w io.Writer, src string, files []TruncatedFile) error {
+	archive := tar.NewWriter(w)
+
+	if files == nil {
+		err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(src, path)
+			if err != nil || relPath == "." {
+				return err
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+			return addTarEntry(archive, path, filepath.ToSlash(relPath), info, info.Size())
+		})
+		if err != nil {
+			return err
+		}
+		return archive.Close()
+	}
+
+	for _, f := range files {
+		path := f.SourcePath(src)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() < f.Size {
+			return fmt.Errorf("%s is shorter than expected (%d < %d bytes)", f.Path, info.Size(), f.Size)
+		}
+		if err := addTarEntry(archive, path, f.Path, info, f.Size); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// addTarEntry adds a directory, or the first size bytes of a file
+func addTarEntry(archive *tar.Writer, path, name string, info os.FileInfo, size int64) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// Owner names depend on the machine and aren't restored
+	header.Uname, header.Gname = "", ""
+
+	if info.IsDir() {
+		header.Name += "/"
+		return archive.WriteHeader(header)
+	}
+
+	header.Size = size
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(archive, file, size)
+	return err
+}
+
+// DetectArchiveFormat tells the format of an archive from its first bytes
+func DetectArchiveFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return "", fmt.Errorf("%s is not an archive", filepath.Base(path))
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")), bytes.Equal(magic, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.Equal(magic[:2], []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return FormatTarZst, nil
+	}
+	return "", fmt.Errorf("%s is not a zip, tar.gz or tar.zst archive", filepath.Base(path))
+}
+
+// tarStream is an open compressed tar archive
+type tarStream struct {
+	*tar.Reader
+	file         *os.File
+	decompressor io.Reader
+	close        func()
+}
+
+func openTar(path, format string) (*tarStream, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &tarStream{file: file, close: func() {}}
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		stream.decompressor, stream.close = gz, func() { gz.Close() }
+	case FormatTarZst:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		stream.decompressor, stream.close = decoder, decoder.Close
+	default:
+		file.Close()
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	stream.Reader = tar.NewReader(stream.decompressor)
+	return stream, nil
+}
+
+// finish reads the rest of the stream so the decompressor checks its checksum
+func (t *tarStream) finish() error {
+	_, err := io.Copy(io.Discard, t.decompressor)
+	return err
+}
+
+func (t *tarStream) Close() {
+	t.close()
+	t.file.Close()
+}
+
+// ExtractArchive extracts a zip, tar.gz or tar.zst archive, detecting the format
+// Unlike ExtractZip, tar archives are checked while they are extracted, so
+// callers should extract into a directory they can remove on error
+func ExtractArchive(path, destPath string) error {
+	format, err := DetectArchiveFormat(path)
+	if err != nil {
+		return err
+	}
+	if format == FormatZip {
+		return ExtractZip(path, destPath)
+	}
+
+	stream, err := openTar(path, format)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		return err
+	}
+
+	var dirs []*tar.Header
+	for {
+		header, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := SafeJoin(destPath, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirInside(destPath, path); err != nil {
+				return err
+			}
+			dirs = append(dirs, header)
+		case tar.TypeReg:
+			perm := header.FileInfo().Mode().Perm()
+			if perm == 0 {
+				perm = 0644
+			}
+			if err := writeExtractedFile(destPath, path, stream, perm, header.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("refusing to extract symlink %s", header.Name)
+		default:
+			return fmt.Errorf("refusing to extract special file %s", header.Name)
+		}
+	}
+	if err := stream.finish(); err != nil {
+		return err
+	}
+
+	// Same as ExtractZip, directories last and deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, _ := SafeJoin(destPath, dirs[i].Name)
+		if perm := dirs[i].FileInfo().Mode().Perm(); perm != 0 {
+			os.Chmod(path, perm)
+		}
+		if !dirs[i].ModTime.IsZero() {
+			os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime)
+		}
+	}
+	return nil
+}
+
+// WalkArchive calls fn with the name and contents of each regular file in an archive
+// The archive's checksums are checked as it is read
+func WalkArchive(path string, fn func(name string, r io.Reader) error) error {
+	format, err := DetectArchiveFormat(path)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatZip {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		for _, file := range reader.File {
+			if file.Mode().IsDir() {
+				continue
+			}
+			rc, err := file.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %v", file.Name, err)
+			}
+			// The zip reader checks each entry's CRC once it is read to the end
+			err = fn(file.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stream, err := openTar(path, format)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for {
+		header, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, stream); err != nil {
+			return err
+		}
+	}
+	return stream.finish()
+}
@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with a header followed by AES-256-GCM sealed chunks
+//
+//	magic (8) | kdf (1) | salt (16)
+//
+// Every chunk holds encryptChunkSize bytes of plaintext except the last one,
+// which may be shorter and is sealed with a final flag in its nonce, so a
+// truncated file fails to authenticate instead of decrypting to less data.
+// The header is authenticated as additional data of every chunk
+const (
+	encryptMagic     = "BSMENC1\n"
+	encryptChunkSize = 64 * 1024
+	encryptSaltSize  = 16
+	encryptHeaderLen = len(encryptMagic) + 1 + encryptSaltSize
+
+	kdfKeyFile    = 0
+	kdfPassphrase = 1
+
+	// scrypt parameters for passphrases, about 100ms per file
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrDecrypt is returned when an encrypted file fails to authenticate
+var ErrDecrypt = errors.New("authentication failed: wrong key or corrupted file")
+
+// EncryptionKey is either a 32 byte key read from a key file or a passphrase
+// A separate file key is derived for every file from a random salt
+type EncryptionKey struct {
+	key        []byte
+	passphrase string
+}
+
+// LoadEncryptionKey reads a key file, or uses the passphrase if no key file is given
+// Key files hold 32 bytes, either raw or hex or base64 encoded
+func LoadEncryptionKey(keyFile, passphrase string) (*EncryptionKey, error) {
+	if keyFile == "" {
+		if passphrase == "" {
+			return nil, fmt.Errorf("encryption needs a key_file or a passphrase")
+		}
+		return &EncryptionKey{passphrase: passphrase}, nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	if len(data) == 32 {
+		return &EncryptionKey{key: data}, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return &EncryptionKey{key: key}, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return &EncryptionKey{key: key}, nil
+	}
+	return nil, fmt.Errorf("key file must contain 32 bytes, raw or hex or base64 encoded")
+}
+
+// fileCipher derives the key for one file from its salt
+func (k *EncryptionKey) fileCipher(kdf byte, salt []byte) (cipher.AEAD, error) {
+	var fileKey []byte
+	switch {
+	case kdf == kdfKeyFile && k.key != nil:
+		mac := hmac.New(sha256.New, k.key)
+		mac.Write(salt)
+		fileKey = mac.Sum(nil)
+	case kdf == kdfPassphrase && k.passphrase != "":
+		var err error
+		fileKey, err = scrypt.Key([]byte(k.passphrase), salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+	case kdf == kdfKeyFile:
+		return nil, fmt.Errorf("file was encrypted with a key file, but a passphrase is configured")
+	case kdf == kdfPassphrase:
+		return nil, fmt.Errorf("file was encrypted with a passphrase, but a key file is configured")
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", kdf)
+	}
+
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the nonce of a chunk from its index and whether it is the last one
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it into w
+// Close must be called to write the final chunk
+func NewEncryptWriter(w io.Writer, key *EncryptionKey) (io.WriteCloser, error) {
+	kdf := byte(kdfKeyFile)
+	if key.key == nil {
+		kdf = kdfPassphrase
+	}
+	salt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := key.fileCipher(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptMagic), kdf)
+	header = append(header, salt...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, the last chunk is sealed by Close
+		if len(e.buf) == encryptChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.index, last), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunk  []byte
+	plain  []byte
+	index  uint64
+	done   bool
+	carry  int // Bytes of the next chunk already read into chunk
+}
+
+// NewDecryptReader returns a reader of the plaintext of an encrypted stream
+// Data is only returned after its chunk has been authenticated
+func NewDecryptReader(r io.Reader, key *EncryptionKey) (io.Reader, error) {
+	header := make([]byte, encryptHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("not an encrypted file: %v", err)
+	}
+	if string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+
+	kdf := header[len(encryptMagic)]
+	aead, err := key.fileCipher(kdf, header[len(encryptMagic)+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		chunk:  make([]byte, encryptChunkSize+aead.Overhead()+1),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next authenticates the following chunk
+// Reading one byte past a full chunk tells whether it is the last one
+func (d *decryptReader) next() error {
+	size := encryptChunkSize + d.aead.Overhead()
+	n, err := io.ReadFull(d.r, d.chunk[d.carry:size+1])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	n += d.carry
+
+	last := n <= size
+	sealed := d.chunk[:min(n, size)]
+	plain, err := d.aead.Open(nil, chunkNonce(d.index, last), sealed, d.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.index++
+
+	if last {
+		d.done = true
+	} else {
+		// Keep the extra byte for the next chunk
+		d.chunk[0] = d.chunk[size]
+		d.carry = 1
+	}
+	d.plain = plain
+	return nil
+}
+
+// IsEncrypted reports whether a file starts with the encryption header
+func IsEncrypted(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return string(magic) == encryptMagic
+}
+
+// DecryptFile writes the decrypted contents of src to dst
+func DecryptFile(src, dst string, key *EncryptionKey) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := NewDecryptReader(in, key)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, reader); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// AuthenticateFile checks every chunk of an encrypted file without writing the plaintext anywhere
+func AuthenticateFile(path string, key *EncryptionKey) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := NewDecryptReader(in, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
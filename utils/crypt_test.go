@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKey returns a key file key made of the given byte repeated
+func testKey(b byte) *EncryptionKey {
+	return &EncryptionKey{key: bytes.Repeat([]byte{b}, 32)}
+}
+
+// testPlaintext returns n bytes that differ from chunk to chunk
+func testPlaintext(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/encryptChunkSize)
+	}
+	return data
+}
+
+func encrypt(t *testing.T, plain []byte, key *EncryptionKey) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, key *EncryptionKey) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// sealedChunkSize is the size of a full chunk in an encrypted file
+const sealedChunkSize = encryptChunkSize + 16
+
+func TestEncryptRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3*encryptChunkSize + 5}
+	for _, size := range sizes {
+		plain := testPlaintext(size)
+		encrypted := encrypt(t, plain, testKey(1))
+
+		chunks := size/encryptChunkSize + 1
+		if size > 0 && size%encryptChunkSize == 0 {
+			chunks--
+		}
+		if want := encryptHeaderLen + size + chunks*16; len(encrypted) != want {
+			t.Errorf("%d bytes encrypted to %d bytes, want %d", size, len(encrypted), want)
+		}
+		if !bytes.HasPrefix(encrypted, []byte(encryptMagic)) {
+			t.Errorf("%d bytes: encrypted file doesn't start with the header", size)
+		}
+
+		got, err := decrypt(encrypted, testKey(1))
+		if err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes decrypted to %d different bytes", size, len(got))
+		}
+	}
+
+	// Small writes go through the same chunking
+	plain := testPlaintext(2*encryptChunkSize + 100)
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(plain); i += 1000 {
+		if _, err := w.Write(plain[i:min(i+1000, len(plain))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decrypt(buf.Bytes(), testKey(1)); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decrypting small writes returned %d bytes, %v", len(got), err)
+	}
+
+	if _, err := decrypt(encrypt(t, plain, testKey(1)), testKey(2)); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("decrypting with the wrong key returned %v, want ErrDecrypt", err)
+	}
+}
+
+func TestDecryptRejectsTruncatedFile(t *testing.T) {
+	encrypted := encrypt(t, testPlaintext(2*encryptChunkSize+100), testKey(1))
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		// Full chunks are sealed as not last, so the file can't end after one
+		{"last chunk removed", encryptHeaderLen + 2*sealedChunkSize},
+		{"last two chunks removed", encryptHeaderLen + sealedChunkSize},
+		{"cut inside a chunk", encryptHeaderLen + sealedChunkSize + 1000},
+		{"last byte removed", len(encrypted) - 1},
+		{"no chunks", encryptHeaderLen},
+	}
+	for _, tt := range tests {
+		_, err := decrypt(encrypted[:tt.size], testKey(1))
+		if !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: got %v, want ErrDecrypt", tt.name, err)
+		}
+	}
+
+	if _, err := decrypt(encrypted[:encryptHeaderLen-1], testKey(1)); err == nil {
+		t.Error("decrypting a truncated header succeeded")
+	}
+}
+
+func TestDecryptRejectsTamperedChunk(t *testing.T) {
+	plain := testPlaintext(3 * encryptChunkSize)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"first chunk", encryptHeaderLen + 10},
+		{"middle chunk", encryptHeaderLen + sealedChunkSize + 10},
+		{"tag of the last chunk", encryptHeaderLen + 3*sealedChunkSize - 1},
+		// The header is authenticated along with every chunk
+		{"salt", len(encryptMagic) + 1},
+	}
+	for _, tt := range tests {
+		encrypted := encrypt(t, plain, testKey(1))
+		encrypted[tt.offset] ^= 0x80
+
+		path := filepath.Join(dir, "tampered.enc")
+		if err := os.WriteFile(path, encrypted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := AuthenticateFile(path, testKey(1)); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: AuthenticateFile returned %v, want ErrDecrypt", tt.name, err)
+		}
+
+		got, err := decrypt(encrypted, testKey(1))
+		if !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: decrypting returned %v, want ErrDecrypt", tt.name, err)
+		}
+		// Only chunks before the tampered one may come out
+		if chunk := (tt.offset - encryptHeaderLen) / sealedChunkSize; len(got) > max(chunk, 0)*encryptChunkSize {
+			t.Errorf("%s: %d bytes were returned from unauthenticated chunks", tt.name, len(got))
+		}
+	}
+
+	path := filepath.Join(dir, "intact.enc")
+	if err := os.WriteFile(path, encrypt(t, plain, testKey(1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuthenticateFile(path, testKey(1)); err != nil {
+		t.Errorf("authenticating an intact file: %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	raw := bytes.Repeat([]byte{0xab}, 32)
+	files := map[string][]byte{
+		"raw":    raw,
+		"hex":    []byte(hex.EncodeToString(raw) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(raw) + "\n"),
+		"short":  []byte(hex.EncodeToString(raw[:20])),
+		"text":   []byte("not a key"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every encoding of the same key decrypts what the others encrypted
+	plain := testPlaintext(1000)
+	encrypted := encrypt(t, plain, &EncryptionKey{key: raw})
+	for _, name := range []string{"raw", "hex", "base64"} {
+		key, err := LoadEncryptionKey(filepath.Join(dir, name), "ignored")
+		if err != nil {
+			t.Errorf("%s key file: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(key.key, raw) || key.passphrase != "" {
+			t.Errorf("%s key file loaded as %+v", name, key)
+		}
+		if got, err := decrypt(encrypted, key); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%s key file failed to decrypt: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"short", "text", "missing"} {
+		if _, err := LoadEncryptionKey(filepath.Join(dir, name), ""); err == nil {
+			t.Errorf("%s key file was accepted", name)
+		}
+	}
+	if _, err := LoadEncryptionKey("", ""); err == nil {
+		t.Error("loading without a key file or passphrase succeeded")
+	}
+
+	// Passphrases derive the file key with scrypt
+	key, err := LoadEncryptionKey("", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted = encrypt(t, plain, key)
+	if encrypted[len(encryptMagic)] != kdfPassphrase {
+		t.Errorf("passphrase file uses key derivation %d, want %d", encrypted[len(encryptMagic)], kdfPassphrase)
+	}
+	if got, err := decrypt(encrypted, key); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("passphrase failed to decrypt: %v", err)
+	}
+
+	wrong, err := LoadEncryptionKey("", "wrong horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypt(encrypted, wrong); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("decrypting with the wrong passphrase returned %v, want ErrDecrypt", err)
+	}
+
+	// A file only opens with the kind of key that encrypted it
+	if _, err := decrypt(encrypted, &EncryptionKey{key: raw}); err == nil || errors.Is(err, ErrDecrypt) {
+		t.Errorf("decrypting a passphrase file with a key file returned %v, want a key mismatch", err)
+	}
+	if _, err := decrypt(encrypt(t, plain, &EncryptionKey{key: raw}), key); err == nil || errors.Is(err, ErrDecrypt) {
+		t.Errorf("decrypting a key file encrypted file with a passphrase returned %v, want a key mismatch", err)
+	}
+}
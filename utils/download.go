@@ -1,11 +1,16 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,51 +30,184 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func DownloadFile(url, destPath string) error {
-	// Create request with headers
+// newRequest creates a GET request with headers that mimic browser behavior
+func newRequest(url string) (*http.Request, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
-	// Add headers to mimic browser behavior
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 	req.Header.Set("Connection", "keep-alive")
 
-	// Create client with timeout
+	return req, nil
+}
+
+// FetchURL downloads a small document such as a web page into memory
+func FetchURL(url string) ([]byte, error) {
+	req, err := newRequest(url)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &http.Client{
-		Timeout: 30 * time.Minute,
+		Timeout: 30 * time.Second,
 	}
 
-	// Send request
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error downloading file: %v", err)
+		return nil, fmt.Errorf("error fetching %s: %v", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
 	}
 
+	return io.ReadAll(resp.Body)
+}
+
+// DownloadOptions controls retries and verification of a download
+type DownloadOptions struct {
+	Retries        int    // Attempts after the first one fails
+	ExpectedSHA256 string // Hex encoded checksum to verify, empty to skip
+	ExpectedSize   int64  // Size in bytes to verify, 0 to skip
+}
+
+// DefaultDownloadOptions retries transient failures without verifying the result
+var DefaultDownloadOptions = DownloadOptions{Retries: 5}
+
+// httpStatusError is returned for responses other than 200 and 206
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("bad status: %s", e.Status)
+}
+
+func DownloadFile(url, destPath string) error {
+	return DownloadFileWithOptions(url, destPath, DefaultDownloadOptions)
+}
+
+// DownloadFileWithOptions downloads url to destPath through a .part file
+// Interrupted transfers are resumed with HTTP range requests and retried with
+// exponential backoff. The file is only moved into place once it passes verification
+func DownloadFileWithOptions(url, destPath string, opts DownloadOptions) error {
 	// Create destination directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return fmt.Errorf("error creating directory: %v", err)
 	}
 
-	// Create the file
-	out, err := os.Create(destPath)
+	partPath := destPath + ".part"
+	for attempt := 0; ; attempt++ {
+		err := downloadPart(url, partPath)
+		if err == nil {
+			break
+		}
+		if !isRetryable(err) || attempt >= opts.Retries {
+			return err
+		}
+
+		backoff := time.Second << attempt
+		if backoff > time.Minute {
+			backoff = time.Minute
+		}
+		fmt.Printf("Download interrupted: %v. Retrying in %s...\n", err, backoff)
+		time.Sleep(backoff)
+	}
+
+	if err := verifyDownload(partPath, opts); err != nil {
+		// A bad partial file can't be resumed into a good one
+		os.Remove(partPath)
+		return err
+	}
+
+	if err := os.Rename(partPath, destPath); err != nil {
+		return fmt.Errorf("error moving download into place: %v", err)
+	}
+
+	return nil
+}
+
+// downloadPart fetches url into partPath, resuming from its current size
+func downloadPart(url, partPath string) error {
+	req, err := newRequest(url)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Create client with timeout
+	client := &http.Client{
+		Timeout: 30 * time.Minute,
+	}
+
+	// Send request
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _ := parseContentRange(resp.Header.Get("Content-Range"))
+		if start != offset {
+			if offset == 0 {
+				return fmt.Errorf("server sent a range starting at %d instead of the whole file", start)
+			}
+			// Appending the wrong range would corrupt the file, start over instead
+			fmt.Printf("Server resumed at byte %d instead of %d, restarting download\n", start, offset)
+			resp.Body.Close()
+			os.Remove(partPath)
+			return downloadPart(url, partPath)
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Server ignored the range, start over
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is complete only if it is as long as the whole file
+		if _, total := parseContentRange(resp.Header.Get("Content-Range")); total == offset {
+			return nil
+		}
+		if offset == 0 {
+			return &httpStatusError{Code: resp.StatusCode, Status: resp.Status}
+		}
+		fmt.Println("Partial download doesn't match the file on the server, restarting download")
+		resp.Body.Close()
+		os.Remove(partPath)
+		return downloadPart(url, partPath)
+	default:
+		return &httpStatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("error creating file: %v", err)
 	}
 	defer out.Close()
 
 	// Setup progress tracking
+	total := resp.ContentLength
+	if total > 0 {
+		total += offset
+	}
 	progressReader := &ProgressReader{
-		Reader: resp.Body,
-		Total:  resp.ContentLength,
+		Reader:     resp.Body,
+		Total:      total,
+		Downloaded: offset,
 		OnProgress: func(downloaded, total int64) {
 			if total > 0 {
 				progress := float64(downloaded) / float64(total) * 100
@@ -84,8 +222,84 @@ func DownloadFile(url, destPath string) error {
 	_, err = io.Copy(out, progressReader)
 	fmt.Println() // New line after progress
 	if err != nil {
-		return fmt.Errorf("error saving file: %v", err)
+		return fmt.Errorf("error saving file: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// parseContentRange returns the first byte and the full size from a Content-Range
+// header such as "bytes 100-199/200" or "bytes */200". Missing values are -1
+func parseContentRange(header string) (int64, int64) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return -1, -1
+	}
+	rng, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return -1, -1
+	}
+
+	start, total := int64(-1), int64(-1)
+	if first, _, ok := strings.Cut(rng, "-"); ok {
+		if n, err := strconv.ParseInt(first, 10, 64); err == nil {
+			start = n
+		}
+	}
+	if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+		total = n
+	}
+	return start, total
+}
+
+// isRetryable reports whether a download error is worth another attempt
+// Only network failures, timeouts, server errors and rate limiting are retried
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+
+	// The client wraps every request error, including bad URLs, in a *url.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if errors.Is(urlErr.Err, io.EOF) {
+			return true // Connection closed before a response arrived
+		}
+		err = urlErr.Err
+	}
+
+	// Timeouts and connection failures
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// The connection closed before the whole body arrived
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// verifyDownload checks a downloaded file against the expected size and checksum
+func verifyDownload(path string, opts DownloadOptions) error {
+	if opts.ExpectedSize > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != opts.ExpectedSize {
+			return fmt.Errorf("size mismatch: expected %d bytes, got %d", opts.ExpectedSize, info.Size())
+		}
+	}
+
+	if opts.ExpectedSHA256 != "" {
+		checksum, err := FileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(checksum, opts.ExpectedSHA256) {
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", opts.ExpectedSHA256, checksum)
+		}
+	}
+
+	return nil
+}
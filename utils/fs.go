@@ -2,12 +2,19 @@ package utils
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // ExtractZip extracts a zip file to the specified destination path
+// Entries with absolute paths, entries that would land outside destPath and
+// symlinks are rejected. File modes and modification times are restored
 func ExtractZip(zipPath, destPath string) error {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -15,41 +22,156 @@ func ExtractZip(zipPath, destPath string) error {
 	}
 	defer reader.Close()
 
+	// Check every entry up front so a bad archive leaves nothing behind
 	for _, file := range reader.File {
-		path := filepath.Join(destPath, file.Name)
+		if _, err := SafeJoin(destPath, file.Name); err != nil {
+			return err
+		}
+
+		mode := file.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract symlink %s", file.Name)
+		}
+		if !mode.IsDir() && !mode.IsRegular() {
+			return fmt.Errorf("refusing to extract special file %s", file.Name)
+		}
+	}
+
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		return err
+	}
+
+	var dirs []*zip.File
+	for _, file := range reader.File {
+		path, _ := SafeJoin(destPath, file.Name)
 
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, 0755)
+		if file.Mode().IsDir() {
+			if err := mkdirInside(destPath, path); err != nil {
+				return err
+			}
+			dirs = append(dirs, file)
 			continue
 		}
 
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		if err := extractZipFile(file, destPath, path); err != nil {
 			return err
 		}
+	}
 
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return err
+	// Directory modes and times are set last, deepest first, since
+	// writing their contents would change them again
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, _ := SafeJoin(destPath, dirs[i].Name)
+		if perm := dirs[i].Mode().Perm(); perm != 0 {
+			os.Chmod(path, perm)
 		}
+		if !dirs[i].Modified.IsZero() {
+			os.Chtimes(path, dirs[i].Modified, dirs[i].Modified)
+		}
+	}
 
-		rc, err := file.Open()
-		if err != nil {
-			outFile.Close()
+	return nil
+}
+
+// extractZipFile writes a single regular file entry to path below destPath
+func extractZipFile(file *zip.File, destPath, path string) error {
+	perm := file.Mode().Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return writeExtractedFile(destPath, path, rc, perm, file.Modified)
+}
+
+// writeExtractedFile writes the contents of an archive entry to path below destPath
+// Never writes through a symlink that already exists at path or any directory above it
+func writeExtractedFile(destPath, path string, r io.Reader, perm os.FileMode, modified time.Time) error {
+	if err := mkdirInside(destPath, filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
 			return err
 		}
+	}
+
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+	_, err = io.Copy(outFile, r)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
+	// OpenFile only applies the mode to new files and is subject to the umask
+	if err := os.Chmod(path, perm); err != nil {
+		return err
+	}
+	if !modified.IsZero() {
+		return os.Chtimes(path, modified, modified)
+	}
+	return nil
+}
+
+// mkdirInside creates dir and the directories between it and dest, refusing
+// to follow a symlink at any of them so nothing is created outside dest
+func mkdirInside(dest, dir string) error {
+	rel, err := filepath.Rel(dest, dir)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	path := dest
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+		case err != nil:
 			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("refusing to extract through symlink %s", path)
+		case !info.IsDir():
+			return fmt.Errorf("refusing to extract into %s, it is not a directory", path)
 		}
 	}
-
 	return nil
 }
 
+// SafeJoin resolves an archive entry name inside dest
+// Returns an error for absolute names and names that escape dest
+func SafeJoin(dest, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("refusing to extract absolute path %s", name)
+	}
+
+	path := filepath.Join(dest, name)
+	rel, err := filepath.Rel(dest, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to extract %s outside of %s", name, dest)
+	}
+
+	return path, nil
+}
+
 func CopyFile(src, dst string) error {
 	source, err := os.Open(src)
 	if err != nil {
@@ -67,18 +189,9 @@ func CopyFile(src, dst string) error {
 	return err
 }
 
-// ZipDirectory creates a zip file containing the contents of the specified directory
-func ZipDirectory(src, dst string) error {
-	zipfile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer zipfile.Close()
-
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+// writeZipDirectory adds the contents of the specified directory to archive
+func writeZipDirectory(archive *zip.Writer, src string) error {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -118,55 +231,101 @@ func ZipDirectory(src, dst string) error {
 		_, err = io.Copy(writer, file)
 		return err
 	})
-}
-
-// UnzipFile extracts a zip file to the specified destination
-func UnzipFile(src, dst string, worldName string) error {
-	reader, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
+	return archive.Close()
+}
 
-	// Ensure the world directory exists
-	worldDir := filepath.Join(dst, worldName)
-	if err := os.MkdirAll(worldDir, 0755); err != nil {
-		return err
-	}
+// CopyPath copies a file or a directory tree from src to dst, keeping file modes
+func CopyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-	for _, file := range reader.File {
-		// Get just the base path without the world name prefix
-		basePath := filepath.Base(file.Name)
-		path := filepath.Join(worldDir, basePath)
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
 
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, file.Mode())
-			continue
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
 		}
 
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		if err := CopyFile(path, target); err != nil {
 			return err
 		}
+		return os.Chmod(target, info.Mode().Perm())
+	})
+}
+
+// FileSHA256 returns the hex encoded SHA-256 checksum of a file
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// TruncatedFile is a file to archive along with how many bytes of it to include
+type TruncatedFile struct {
+	Path   string // Relative to the source directory, slash separated
+	Size   int64
+	Source string // Read from this path instead of the source directory if set
+}
+
+// SourcePath returns where to read the file from when archiving src
+func (f TruncatedFile) SourcePath(src string) string {
+	if f.Source != "" {
+		return f.Source
+	}
+	return filepath.Join(src, filepath.FromSlash(f.Path))
+}
 
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+// writeZipTruncatedFiles adds the first Size bytes of each listed file to archive
+// Used for live copies where files may still grow while they are read
+func writeZipTruncatedFiles(archive *zip.Writer, src string, files []TruncatedFile) error {
+	for _, f := range files {
+		path := f.SourcePath(src)
+		info, err := os.Stat(path)
 		if err != nil {
 			return err
 		}
+		if info.Size() < f.Size {
+			return fmt.Errorf("%s is shorter than expected (%d < %d bytes)", f.Path, info.Size(), f.Size)
+		}
 
-		rc, err := file.Open()
+		header, err := zip.FileInfoHeader(info)
 		if err != nil {
-			outFile.Close()
 			return err
 		}
+		header.Name = f.Path
+		header.Method = zip.Deflate
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
 
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(writer, file, f.Size)
+		file.Close()
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	return archive.Close()
+}
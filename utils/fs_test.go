@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is a file, directory or symlink to put in a test archive
+type archiveEntry struct {
+	name   string
+	body   string
+	dir    bool
+	target string // Symlink target, empty for other entries
+}
+
+func writeTestZip(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch {
+		case e.dir:
+			header.SetMode(os.ModeDir | 0755)
+		case e.target != "":
+			header.SetMode(os.ModeSymlink | 0777)
+			body = e.target
+		default:
+			header.SetMode(0644)
+		}
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		case e.target != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.target, 0
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := archive.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertEmpty fails if anything was written to dir
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s was written outside the destination", entry.Name())
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		// linkParent creates dest/link pointing at the outside directory first
+		linkParent bool
+	}{
+		{
+			name:    "parent directory",
+			entries: []archiveEntry{{name: "ok.txt", body: "ok"}, {name: "../escaped.txt", body: "bad"}},
+		},
+		{
+			name:    "nested parent directory",
+			entries: []archiveEntry{{name: "a/../../escaped.txt", body: "bad"}},
+		},
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{{name: "/escaped.txt", body: "bad"}},
+		},
+		{
+			name:    "symlink entry",
+			entries: []archiveEntry{{name: "link", target: ".."}, {name: "link/escaped.txt", body: "bad"}},
+		},
+		{
+			name:       "symlinked parent directory",
+			entries:    []archiveEntry{{name: "link/escaped.txt", body: "bad"}},
+			linkParent: true,
+		},
+		{
+			name:       "directory below symlinked parent",
+			entries:    []archiveEntry{{name: "link/sub/", dir: true}, {name: "link/sub/escaped.txt", body: "bad"}},
+			linkParent: true,
+		},
+	}
+
+	formats := []struct {
+		ext     string
+		write   func(*testing.T, string, []archiveEntry)
+		extract func(string, string) error
+	}{
+		{"zip", writeTestZip, ExtractZip},
+		{"tar.gz", writeTestTarGz, ExtractArchive},
+		{"zip via ExtractArchive", writeTestZip, ExtractArchive},
+	}
+
+	for _, format := range formats {
+		for _, tt := range tests {
+			t.Run(format.ext+"/"+tt.name, func(t *testing.T) {
+				root := t.TempDir()
+				outside := filepath.Join(root, "outside")
+				dest := filepath.Join(root, "dest")
+				for _, dir := range []string{outside, dest} {
+					if err := os.Mkdir(dir, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tt.linkParent {
+					if err := os.Symlink(outside, filepath.Join(dest, "link")); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				archivePath := filepath.Join(root, "archive")
+				format.write(t, archivePath, tt.entries)
+
+				if err := format.extract(archivePath, dest); err == nil {
+					t.Fatal("expected an error")
+				}
+
+				assertEmpty(t, outside)
+				if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+					t.Error("escaped.txt was written outside the destination")
+				}
+				if _, err := os.Stat("/escaped.txt"); err == nil {
+					t.Error("/escaped.txt was written outside the destination")
+				}
+			})
+		}
+	}
+}
+
+func TestExtractZipWritesFiles(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "archive.zip")
+	writeTestZip(t, archivePath, []archiveEntry{
+		{name: "a/", dir: true},
+		{name: "a/b.txt", body: "hello"},
+	})
+
+	dest := filepath.Join(root, "dest")
+	if err := ExtractZip(archivePath, dest); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "a", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("got %q, want %q", data, "hello")
+	}
+}
@@ -38,4 +38,17 @@ func PromptBool(prompt string, defaultValue bool) bool {
 	}
 	input := strings.ToLower(PromptString(prompt, defaultStr))
 	return input == "yes" || input == "y" || (input == "" && defaultValue)
-}
\ No newline at end of file
+}
+
+// IsInteractive reports whether stdin is a terminal that can answer prompts
+func IsInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// The null device is a character device too, but nobody is there to answer
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}